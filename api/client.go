@@ -0,0 +1,193 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go_mailer/config"
+	"go_mailer/logger"
+	"go_mailer/scheduler"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sheetRetryMaxDelay caps the delay between attempts, including delays asked
+// for with Retry-After
+const sheetRetryMaxDelay = time.Minute
+
+// sheetClient makes the requests to the Google Sheet API. Requests time out,
+// carry the configured credentials and are retried with backoff when the API
+// is overloaded or failing (429 and 5xx replies, network errors).
+type sheetClient struct {
+	http        *http.Client
+	maxAttempts int
+	retryDelay  time.Duration
+	maxResponse int64       // 0 for no limit
+	header      http.Header // Added to every request
+}
+
+// sheetClients holds one client per configuration, so that connections are
+// reused between requests
+var sheetClients sync.Map
+
+// sheetClientFor returns the client for the configuration
+func sheetClientFor(cfg *config.Config) *sheetClient {
+	if client, ok := sheetClients.Load(cfg); ok {
+		return client.(*sheetClient)
+	}
+	client, _ := sheetClients.LoadOrStore(cfg, newSheetClient(cfg))
+	return client.(*sheetClient)
+}
+
+// newSheetClient creates a client with the timeouts, retry policy, limits and
+// credentials in the configuration
+func newSheetClient(cfg *config.Config) *sheetClient {
+	dialer := &net.Dialer{Timeout: cfg.SheetAPIConnectTimeout}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = cfg.SheetAPIConnectTimeout
+
+	header := make(http.Header)
+	if cfg.SheetAPIToken != "" {
+		header.Set("Authorization", "Bearer "+cfg.SheetAPIToken)
+	}
+	if cfg.SheetAPISecret != "" {
+		header.Set(cfg.SheetAPISecretHeader, cfg.SheetAPISecret)
+	}
+
+	client := &sheetClient{
+		http:        &http.Client{Timeout: cfg.SheetAPITimeout, Transport: transport},
+		maxAttempts: cfg.SheetAPIMaxAttempts,
+		retryDelay:  cfg.SheetAPIRetryDelay,
+		maxResponse: cfg.SheetAPIMaxResponseSize,
+		header:      header,
+	}
+	if client.maxAttempts < 1 {
+		client.maxAttempts = 1
+	}
+	return client
+}
+
+// statusError is a non-OK reply from the API
+type statusError struct {
+	status     string
+	code       int
+	retryAfter time.Duration // Delay asked for with Retry-After, 0 if none
+}
+
+func (e *statusError) Error() string {
+	return "received non-OK response status: " + e.status
+}
+
+// retryable reports whether the reply is worth retrying
+func (e *statusError) retryable() bool {
+	return e.code == http.StatusTooManyRequests || e.code >= 500
+}
+
+// do sends a request with an optional JSON body and returns the body of the
+// OK reply
+func (c *sheetClient) do(method, url string, body []byte) ([]byte, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var respBody []byte
+		respBody, err = c.attempt(method, url, body)
+		if err == nil {
+			return respBody, nil
+		}
+
+		retry := true
+		var wait time.Duration
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			retry = statusErr.retryable()
+			wait = statusErr.retryAfter
+		} else if errors.Is(err, errResponseTooLarge) {
+			retry = false
+		}
+		if !retry || attempt >= c.maxAttempts {
+			break
+		}
+
+		if wait == 0 {
+			wait = scheduler.RetryDelay(attempt, c.retryDelay, sheetRetryMaxDelay)
+		}
+		if wait > sheetRetryMaxDelay {
+			wait = sheetRetryMaxDelay
+		}
+		logger.Warning("🔁 Google Sheet API request failed (attempt %d/%d), retrying in %v: %v",
+			attempt, c.maxAttempts, wait.Round(time.Millisecond), err)
+		time.Sleep(wait)
+	}
+
+	return nil, err
+}
+
+// errResponseTooLarge is returned for replies over the size limit
+var errResponseTooLarge = errors.New("response too large")
+
+// attempt makes a single request
+func (c *sheetClient) attempt(method, url string, body []byte) ([]byte, error) {
+	var requestBody io.Reader
+	if body != nil {
+		requestBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Drain a little of the body so that the connection can be reused
+		io.CopyN(io.Discard, resp.Body, 4096)
+		return nil, &statusError{
+			status:     resp.Status,
+			code:       resp.StatusCode,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	var reader io.Reader = resp.Body
+	if c.maxResponse > 0 {
+		reader = io.LimitReader(resp.Body, c.maxResponse+1)
+	}
+	respBody, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if c.maxResponse > 0 && int64(len(respBody)) > c.maxResponse {
+		return nil, fmt.Errorf("%w: over %d bytes", errResponseTooLarge, c.maxResponse)
+	}
+	return respBody, nil
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as a date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(t); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"errors"
+	"go_mailer/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClientConfig returns client settings with short delays for tests
+func testClientConfig() *config.Config {
+	return &config.Config{
+		SheetAPITimeout:        5 * time.Second,
+		SheetAPIConnectTimeout: 5 * time.Second,
+		SheetAPIMaxAttempts:    3,
+		SheetAPIRetryDelay:     time.Millisecond,
+	}
+}
+
+// countingServer answers every request with handler and counts the requests
+func countingServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, n int)) (*httptest.Server, *int32) {
+	t.Helper()
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, int(atomic.AddInt32(&count, 1)))
+	}))
+	t.Cleanup(server.Close)
+	return server, &count
+}
+
+func TestClientRetriesServerErrors(t *testing.T) {
+	for _, code := range []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusTooManyRequests} {
+		server, count := countingServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+			if n < 3 {
+				w.WriteHeader(code)
+				return
+			}
+			io.WriteString(w, `{"ok":true}`)
+		})
+
+		body, err := newSheetClient(testClientConfig()).do(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatalf("%d: do: %v", code, err)
+		}
+		if string(body) != `{"ok":true}` {
+			t.Errorf("%d: body = %q", code, body)
+		}
+		if *count != 3 {
+			t.Errorf("%d: %d requests, want 3", code, *count)
+		}
+	}
+}
+
+func TestClientGivesUpAfterMaxAttempts(t *testing.T) {
+	server, count := countingServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	_, err := newSheetClient(testClientConfig()).do(http.MethodGet, server.URL, nil)
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) || statusErr.code != http.StatusServiceUnavailable {
+		t.Fatalf("error = %v, want a 503 status error", err)
+	}
+	if *count != 3 {
+		t.Errorf("%d requests, want 3", *count)
+	}
+}
+
+func TestClientDoesNotRetryClientErrors(t *testing.T) {
+	for _, code := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound} {
+		server, count := countingServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+			w.WriteHeader(code)
+		})
+
+		_, err := newSheetClient(testClientConfig()).do(http.MethodGet, server.URL, nil)
+		var statusErr *statusError
+		if !errors.As(err, &statusErr) || statusErr.code != code {
+			t.Errorf("%d: error = %v, want a status error", code, err)
+		}
+		if *count != 1 {
+			t.Errorf("%d: %d requests, want 1", code, *count)
+		}
+	}
+}
+
+func TestClientHonoursRetryAfter(t *testing.T) {
+	var first time.Time
+	var gap time.Duration
+	server, _ := countingServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		if n == 1 {
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		gap = time.Since(first)
+		io.WriteString(w, "ok")
+	})
+
+	if _, err := newSheetClient(testClientConfig()).do(http.MethodGet, server.URL, nil); err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	if gap < 900*time.Millisecond {
+		t.Errorf("retried after %v, want the 1s asked for with Retry-After", gap)
+	}
+}
+
+func TestClientRetriesNetworkErrors(t *testing.T) {
+	server, count := countingServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		if n == 1 {
+			// Drop the connection without a reply
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		io.WriteString(w, "ok")
+	})
+
+	if _, err := newSheetClient(testClientConfig()).do(http.MethodGet, server.URL, nil); err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	if *count != 2 {
+		t.Errorf("%d requests, want 2", *count)
+	}
+}
+
+func TestClientResponseSizeLimit(t *testing.T) {
+	server, count := countingServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		io.WriteString(w, strings.Repeat("x", 1024))
+	})
+
+	cfg := testClientConfig()
+	cfg.SheetAPIMaxResponseSize = 1024
+	if body, err := newSheetClient(cfg).do(http.MethodGet, server.URL, nil); err != nil || len(body) != 1024 {
+		t.Fatalf("response at the limit: %d bytes, %v", len(body), err)
+	}
+
+	cfg = testClientConfig()
+	cfg.SheetAPIMaxResponseSize = 1023
+	*count = 0
+	_, err := newSheetClient(cfg).do(http.MethodGet, server.URL, nil)
+	if !errors.Is(err, errResponseTooLarge) {
+		t.Fatalf("error = %v, want errResponseTooLarge", err)
+	}
+	if *count != 1 {
+		t.Errorf("%d requests for an oversized response, want 1", *count)
+	}
+}
+
+func TestClientSendsCredentials(t *testing.T) {
+	var header http.Header
+	server, _ := countingServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		header = r.Header.Clone()
+		io.WriteString(w, "ok")
+	})
+
+	cfg := testClientConfig()
+	cfg.SheetAPIToken = "token123"
+	cfg.SheetAPISecret = "s3cret"
+	cfg.SheetAPISecretHeader = "X-Sheet-Secret"
+	if _, err := newSheetClient(cfg).do(http.MethodPost, server.URL, []byte(`{}`)); err != nil {
+		t.Fatalf("do: %v", err)
+	}
+
+	if got := header.Get("Authorization"); got != "Bearer token123" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := header.Get("X-Sheet-Secret"); got != "s3cret" {
+		t.Errorf("X-Sheet-Secret = %q", got)
+	}
+	if got := header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	// Without credentials configured no auth headers are sent
+	if _, err := newSheetClient(testClientConfig()).do(http.MethodGet, server.URL, nil); err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	if got := header.Get("Authorization"); got != "" {
+		t.Errorf("Authorization = %q without a token", got)
+	}
+	if got := header.Get("Content-Type"); got != "" {
+		t.Errorf("Content-Type = %q without a body", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("120"); got != 2*time.Minute {
+		t.Errorf("seconds: %v", got)
+	}
+	if got := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("date: %v", got)
+	}
+	for _, value := range []string{"", "0", "-5", "soon", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)} {
+		if got := parseRetryAfter(value); got != 0 {
+			t.Errorf("%q: %v, want 0", value, got)
+		}
+	}
+}
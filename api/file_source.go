@@ -0,0 +1,354 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_mailer/logger"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileTable is the content of a local recipient file: its columns in order
+// and one map per row
+type fileTable struct {
+	columns []string
+	rows    []map[string]interface{}
+	ndjson  bool // JSON files only: one object per line rather than an array
+}
+
+// fileSource is the behaviour shared by the CSV and JSON sources. The file
+// is read on every check, so it can be edited while the service runs, and
+// rewritten in place when a row's status changes.
+type fileSource struct {
+	path  string
+	read  func(content []byte) (*fileTable, error)
+	write func(table *fileTable) ([]byte, error)
+	mu    sync.Mutex
+}
+
+// load reads and parses the file
+func (s *fileSource) load() (*fileTable, error) {
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading recipients: %w", err)
+	}
+	table, err := s.read(content)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", s.path, err)
+	}
+	return table, nil
+}
+
+// FetchPending returns the rows of the file that have not been sent yet.
+// Rows without a RowID are given a UUID, which is saved to the file so that
+// it stays the same on the next check. Rows that cannot be decoded are
+// logged and skipped.
+func (s *fileSource) FetchPending() ([]SheetData, error) {
+	s.mu.Lock()
+	table, err := s.load()
+	if err == nil {
+		err = s.assignRowIDs(table)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []SheetData
+	for i, columns := range table.rows {
+		row, err := decodeRow(columns)
+		if err != nil {
+			logger.Error("❌ Skipping row %d of %s: %v", i+1, s.path, err)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return pendingRows(rows), nil
+}
+
+// assignRowIDs gives every row without a RowID a new one and saves the file
+// if any changed
+func (s *fileSource) assignRowIDs(table *fileTable) error {
+	assigned := 0
+	for _, row := range table.rows {
+		if cellString(row["RowID"]) != "" {
+			continue
+		}
+		id, err := newRowID()
+		if err != nil {
+			return fmt.Errorf("error generating row ID: %w", err)
+		}
+		row["RowID"] = id
+		assigned++
+	}
+	if assigned == 0 {
+		return nil
+	}
+
+	logger.Info("🆔 Assigned IDs to %d rows of %s", assigned, s.path)
+	return s.save(table, "RowID")
+}
+
+// UpdateStatus writes the row's status to its SendStatus, Status, SentAt,
+// MessageID, Attempts and SendError columns
+func (s *fileSource) UpdateStatus(rowID string, status RowStatus) error {
+	return s.update(rowID, func(row map[string]interface{}) {
+		row["SendStatus"] = status.Status == RowSent
+		row["Status"] = status.Status
+		row["SentAt"] = ""
+		if !status.SentAt.IsZero() {
+			row["SentAt"] = status.SentAt.Format(time.RFC3339)
+		}
+		row["MessageID"] = status.MessageID
+		row["Attempts"] = status.Attempts
+		row["SendError"] = status.Error
+	})
+}
+
+// update applies change to the row with the ID and rewrites the file
+func (s *fileSource) update(rowID string, change func(row map[string]interface{})) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	table, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	matched := false
+	for _, row := range table.rows {
+		if cellString(row["RowID"]) == rowID {
+			change(row)
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return fmt.Errorf("no row with ID %s in %s", rowID, s.path)
+	}
+
+	return s.save(table, "SendStatus", "Status", "SentAt", "MessageID", "Attempts", "SendError")
+}
+
+// save rewrites the file, adding any of the columns it lacks
+func (s *fileSource) save(table *fileTable, columns ...string) error {
+	for _, column := range columns {
+		if !containsString(table.columns, column) {
+			table.columns = append(table.columns, column)
+		}
+	}
+
+	content, err := s.write(table)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, content)
+}
+
+// cellString returns a cell's value as trimmed text
+func cellString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(value))
+}
+
+// writeFileAtomic replaces the file at path, so that a crash mid-write never
+// leaves it truncated
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CSVSource reads recipients from a CSV file whose header row uses the
+// Google Sheet's column names
+type CSVSource struct {
+	fileSource
+}
+
+// NewCSVSource creates a source for the CSV file at path
+func NewCSVSource(path string) *CSVSource {
+	return &CSVSource{fileSource{path: path, read: readCSV, write: writeCSV}}
+}
+
+// Name describes the source in log messages
+func (s *CSVSource) Name() string {
+	return "CSV file " + s.path
+}
+
+// readCSV parses a CSV file with a header row. Blank lines are skipped.
+func readCSV(content []byte) (*fileTable, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(content, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return &fileTable{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	table := &fileTable{}
+	for _, column := range header {
+		table.columns = append(table.columns, strings.TrimSpace(column))
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(table.columns))
+		blank := true
+		for i, column := range table.columns {
+			if i < len(record) && column != "" {
+				row[column] = record[i]
+				blank = blank && strings.TrimSpace(record[i]) == ""
+			}
+		}
+		if !blank {
+			table.rows = append(table.rows, row)
+		}
+	}
+	return table, nil
+}
+
+// writeCSV formats the table as CSV
+func writeCSV(table *fileTable) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(table.columns); err != nil {
+		return nil, err
+	}
+
+	for _, row := range table.rows {
+		record := make([]string, len(table.columns))
+		for i, column := range table.columns {
+			switch value := row[column].(type) {
+			case nil:
+			case bool:
+				record[i] = strconv.FormatBool(value)
+			default:
+				record[i] = fmt.Sprint(value)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// JSONSource reads recipients from a JSON file holding either an array of
+// row objects or one object per line (NDJSON), keyed by the Google Sheet's
+// column names
+type JSONSource struct {
+	fileSource
+}
+
+// NewJSONSource creates a source for the JSON or NDJSON file at path
+func NewJSONSource(path string) *JSONSource {
+	return &JSONSource{fileSource{path: path, read: readJSON, write: writeJSON}}
+}
+
+// Name describes the source in log messages
+func (s *JSONSource) Name() string {
+	return "JSON file " + s.path
+}
+
+// readJSON parses a JSON array of objects or a stream of objects
+func readJSON(content []byte) (*fileTable, error) {
+	content = bytes.TrimSpace(bytes.TrimPrefix(content, []byte("\ufeff")))
+	table := &fileTable{ndjson: len(content) == 0 || content[0] != '['}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if !table.ndjson {
+		if err := decoder.Decode(&table.rows); err != nil {
+			return nil, err
+		}
+	} else {
+		for {
+			var row map[string]interface{}
+			err := decoder.Decode(&row)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", len(table.rows)+1, err)
+			}
+			table.rows = append(table.rows, row)
+		}
+	}
+
+	for _, row := range table.rows {
+		for column := range row {
+			if !containsString(table.columns, column) {
+				table.columns = append(table.columns, column)
+			}
+		}
+	}
+	return table, nil
+}
+
+// writeJSON formats the table in the layout it was read in
+func writeJSON(table *fileTable) ([]byte, error) {
+	if !table.ndjson {
+		content, err := json.MarshalIndent(table.rows, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(content, '\n'), nil
+	}
+
+	var buf bytes.Buffer
+	for _, row := range table.rows {
+		line, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
@@ -2,11 +2,14 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go_mailer/config"
-	"io"
+	"go_mailer/logger"
 	"net/http"
-	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,37 +21,180 @@ type GoogleSheetResponse struct {
 
 // SheetData represents each entry in the Google Sheet
 type SheetData struct {
+	RowID        string    `json:"RowID"` // Identifies the row in its source; see FetchPending
 	CompanyName  string    `json:"CompanyName"`
 	Roll         string    `json:"Roll"`
 	EmployeeName string    `json:"EmployeeName"`
 	Email        string    `json:"Email"`
+	Cc           string    `json:"Cc"`      // Optional comma-separated CC list, e.g. a referrer
+	ReplyTo      string    `json:"ReplyTo"` // Optional Reply-To, overrides REPLY_TO
 	TemplateName string    `json:"TemplateName"`
+	Subject      string    `json:"Subject"`     // Optional, overrides the template's subject
+	Attachments  string    `json:"Attachments"` // Comma-separated names of ATTACHMENT_SETS to include
 	SendAtDate   time.Time `json:"SendAtDate"`
 	SendAtTime   time.Time `json:"SendAtTime"`
 	SendStatus   bool      `json:"SendStatus"`
+	Status       string    `json:"Status"`    // Last RowStatus written back, e.g. "bounced"
+	SendError    string    `json:"SendError"` // Why the email last failed
+
+	// Extra holds every other column of the row, keyed by column header, so
+	// that new placeholders need no code change
+	Extra map[string]interface{} `json:"-"`
 }
 
-// FetchGoogleSheetData makes a request to the Google Sheet API and returns the parsed data
-func FetchGoogleSheetData(cfg *config.Config) (*GoogleSheetResponse, error) {
-	// Google Sheet API URL
-	apiURL := cfg.GOOGEL_SHEET_API
+// sheetDataColumns are the column headers decoded into SheetData's fields
+var sheetDataColumns = func() map[string]bool {
+	columns := make(map[string]bool)
+	t := reflect.TypeOf(SheetData{})
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			columns[name] = true
+		}
+	}
+	return columns
+}()
+
+// UnmarshalJSON decodes the known columns into their fields and collects
+// every other column into Extra
+func (d *SheetData) UnmarshalJSON(data []byte) error {
+	// The alias type has no methods, which avoids recursing into UnmarshalJSON
+	type sheetData SheetData
+	// RowID may be a UUID or a row number, so it is decoded separately
+	row := struct {
+		*sheetData
+		RowID interface{} `json:"RowID"`
+	}{sheetData: (*sheetData)(d)}
+	if err := json.Unmarshal(data, &row); err != nil {
+		return err
+	}
+	switch id := row.RowID.(type) {
+	case nil:
+		d.RowID = ""
+	case string:
+		d.RowID = strings.TrimSpace(id)
+	case float64:
+		d.RowID = strconv.FormatFloat(id, 'f', -1, 64)
+	default:
+		return fmt.Errorf("invalid RowID %v", id)
+	}
+
+	var columns map[string]interface{}
+	if err := json.Unmarshal(data, &columns); err != nil {
+		return err
+	}
+
+	d.Extra = make(map[string]interface{})
+	for column, value := range columns {
+		if !sheetDataColumns[column] && !statusColumns[column] {
+			d.Extra[column] = value
+		}
+	}
+
+	return nil
+}
+
+// GoogleSheetSource reads recipients from the Google Sheet Apps Script
+// endpoint at GOOGEL_SHEET_API. Status updates go through an outbox that
+// sends them in batches and keeps them until the sheet has accepted them.
+type GoogleSheetSource struct {
+	cfg      *config.Config
+	outbox   *statusOutbox
+	readOnly bool // The outbox is only read, never flushed
+}
 
-	// Make GET request
-	resp, err := http.Get(apiURL)
+// NewGoogleSheetSource creates a source for the configured Google Sheet and
+// starts flushing its status outbox
+func NewGoogleSheetSource(cfg *config.Config) (*GoogleSheetSource, error) {
+	outbox, err := openStatusOutbox(cfg.StatusOutboxPath, cfg.StatusFlushInterval, cfg.StatusBatchSize,
+		func(updates []rowStatusUpdate) error {
+			return updateRowStatuses(updates, cfg)
+		})
 	if err != nil {
-		return nil, fmt.Errorf("error making request to Google Sheet API: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return &GoogleSheetSource{cfg: cfg, outbox: outbox}, nil
+}
 
-	// Check if response status code is OK
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("received non-OK response status: %s", resp.Status)
+// NewReadOnlyGoogleSheetSource creates a source that only reads the sheet,
+// e.g. for a preview. Its status outbox is read so that the same rows are
+// left out, but it is not flushed, and UpdateStatus fails.
+func NewReadOnlyGoogleSheetSource(cfg *config.Config) (*GoogleSheetSource, error) {
+	outbox, err := loadStatusOutbox(cfg.StatusOutboxPath, cfg.StatusFlushInterval, cfg.StatusBatchSize, nil)
+	if err != nil {
+		return nil, err
 	}
+	return &GoogleSheetSource{cfg: cfg, outbox: outbox, readOnly: true}, nil
+}
+
+// Name describes the source in log messages
+func (s *GoogleSheetSource) Name() string {
+	return "Google Sheet"
+}
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+// FetchPending returns the rows of the sheet that have not been sent yet.
+// Rows are identified by the sheet's RowID column, which the Apps Script can
+// fill with Utilities.getUuid(). Without one, a row's ID is its row number,
+// which changes if rows above it are inserted or deleted, and is derived from
+// its position in the response: the Apps Script must then return every data
+// row, unfiltered and in sheet order, starting with the second. Rows whose final
+// status is still waiting in the outbox are left out, so that an email is
+// not sent again while the sheet is unreachable.
+func (s *GoogleSheetSource) FetchPending() ([]SheetData, error) {
+	response, err := FetchGoogleSheetData(s.cfg)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
+		return nil, err
+	}
+	if response.Status != "success" {
+		return nil, fmt.Errorf("Google Sheet API returned non-success status: %s", response.Status)
+	}
+	for i := range response.Data {
+		if response.Data[i].RowID == "" {
+			// Data starts on the second row, below the header, and the
+			// response holds every row in order
+			response.Data[i].RowID = strconv.Itoa(i + 2)
+		}
+	}
+
+	rows := pendingRows(response.Data)
+	pending := rows[:0]
+	for _, row := range rows {
+		if update, ok := s.outbox.queued(row.RowID); ok {
+			switch update.Status {
+			case RowSent, RowBounced, RowCancelled, RowInterrupted:
+				logger.Info("⏭️ Skipping row %s (%s) - its %s status is waiting to be written to the sheet", row.RowID, row.Email, update.Status)
+				continue
+			}
+		}
+		pending = append(pending, row)
+	}
+	return pending, nil
+}
+
+// UpdateStatus queues the row's status for the sheet
+func (s *GoogleSheetSource) UpdateStatus(rowID string, status RowStatus) error {
+	if s.readOnly {
+		return errors.New("the Google Sheet source is read-only")
+	}
+	return s.outbox.enqueue(newRowStatusUpdate(rowID, status))
+}
+
+// Close sends the queued status updates and stops the outbox
+func (s *GoogleSheetSource) Close() error {
+	if s.readOnly {
+		return nil
+	}
+	return s.outbox.close()
+}
+
+// FetchGoogleSheetData makes a request to the Google Sheet API and returns the parsed data
+func FetchGoogleSheetData(cfg *config.Config) (*GoogleSheetResponse, error) {
+	// Google Sheet API URL
+	apiURL := cfg.GOOGEL_SHEET_API
+
+	// Make GET request; the client retries when the API is failing
+	body, err := sheetClientFor(cfg).do(http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error making request to Google Sheet API: %w", err)
 	}
 
 	// Parse JSON response into struct
@@ -60,47 +206,78 @@ func FetchGoogleSheetData(cfg *config.Config) (*GoogleSheetResponse, error) {
 	return &sheetResponse, nil
 }
 
-// UpdateSendStatus updates the send status for an email in the Google Sheet
-func UpdateSendStatus(email string, sendStatus bool, cfg *config.Config) error {
-	// Base API URL
-	baseURL := cfg.GOOGEL_SHEET_API
+// rowStatusUpdate is the JSON body of a status update sent to the Apps Script
+type rowStatusUpdate struct {
+	Action     string `json:"action"`
+	RowID      string `json:"rowId"`
+	Status     string `json:"status"`
+	SendStatus bool   `json:"sendStatus"`
+	SentAt     string `json:"sentAt"` // RFC 3339, empty until sent
+	MessageID  string `json:"messageId"`
+	Attempts   int    `json:"attempts"`
+	Error      string `json:"error"`
+}
 
-	// Build URL with query parameters
-	params := url.Values{}
-	params.Add("action", "update")
-	params.Add("email", email)
-	params.Add("sendStatus", fmt.Sprintf("%t", sendStatus))
+// newRowStatusUpdate builds the update body for a row
+func newRowStatusUpdate(rowID string, status RowStatus) rowStatusUpdate {
+	update := rowStatusUpdate{
+		Action:     "update",
+		RowID:      rowID,
+		Status:     status.Status,
+		SendStatus: status.Status == RowSent,
+		MessageID:  status.MessageID,
+		Attempts:   status.Attempts,
+		Error:      status.Error,
+	}
+	if !status.SentAt.IsZero() {
+		update.SentAt = status.SentAt.Format(time.RFC3339)
+	}
+	return update
+}
 
-	updateURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
+// UpdateRowStatus writes the status of a row to the Google Sheet with a POST
+// of a JSON body. The Apps Script finds the row by its RowID column, or by
+// its row number when the sheet has none, and sets SendStatus when the
+// status is "sent".
+func UpdateRowStatus(rowID string, status RowStatus, cfg *config.Config) error {
+	return postSheetUpdate(newRowStatusUpdate(rowID, status), cfg)
+}
 
-	// Make GET request to update the status
-	resp, err := http.Get(updateURL)
-	if err != nil {
-		return fmt.Errorf("error making request to update send status: %w", err)
-	}
-	defer resp.Body.Close()
+// updateRowStatuses writes the status of several rows to the Google Sheet in
+// one request, a POST of {"action": "updateBatch", "updates": [...]} where
+// each update has the fields of a single update
+func updateRowStatuses(updates []rowStatusUpdate, cfg *config.Config) error {
+	return postSheetUpdate(struct {
+		Action  string            `json:"action"`
+		Updates []rowStatusUpdate `json:"updates"`
+	}{"updateBatch", updates}, cfg)
+}
 
-	// Check if response status code is OK
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("received non-OK response status when updating send status: %s", resp.Status)
+// postSheetUpdate posts an update request to the Apps Script and checks
+// that it reports success
+func postSheetUpdate(request interface{}, cfg *config.Config) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error encoding status update: %w", err)
 	}
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	// Make POST request to update the status; the client retries when the
+	// API is failing
+	respBody, err := sheetClientFor(cfg).do(http.MethodPost, cfg.GOOGEL_SHEET_API, body)
 	if err != nil {
-		return fmt.Errorf("error reading response body for update: %w", err)
+		return fmt.Errorf("error making request to update send status: %w", err)
 	}
 
 	// Parse JSON response to check status
 	var response map[string]interface{}
-	if err := json.Unmarshal(body, &response); err != nil {
+	if err := json.Unmarshal(respBody, &response); err != nil {
 		return fmt.Errorf("error parsing JSON response for update: %w", err)
 	}
 
 	// Check if update was successful
-	status, ok := response["status"].(string)
-	if !ok || status != "success" {
-		return fmt.Errorf("update was not successful, response: %s", string(body))
+	result, ok := response["status"].(string)
+	if !ok || result != "success" {
+		return fmt.Errorf("update was not successful, response: %s", string(respBody))
 	}
 
 	return nil
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadOnlyGoogleSheetSourceDoesNotFlush(t *testing.T) {
+	var posts int32
+	server, _ := countingServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		if r.Method == http.MethodPost {
+			atomic.AddInt32(&posts, 1)
+			io.WriteString(w, `{"status":"success"}`)
+			return
+		}
+		io.WriteString(w, `{"status":"success","data":[{"Email":"a@example.com","SendStatus":false},{"Email":"b@example.com","SendStatus":false}]}`)
+	})
+
+	// A sent status for row 2 is still waiting from an earlier run
+	cfg := testClientConfig()
+	cfg.GOOGEL_SHEET_API = server.URL
+	cfg.StatusOutboxPath = filepath.Join(t.TempDir(), "outbox.json")
+	cfg.StatusFlushInterval = 10 * time.Millisecond
+	cfg.StatusBatchSize = 1
+	saved := []byte(`[{"update":{"rowId":"2","status":"sent"}}]`)
+	if err := os.WriteFile(cfg.StatusOutboxPath, saved, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := NewReadOnlyRecipientSource(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := source.FetchPending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].RowID != "3" {
+		t.Errorf("pending rows %+v, want only row 3", rows)
+	}
+	if err := source.UpdateStatus("3", RowStatus{Status: RowScheduled}); err == nil {
+		t.Error("read-only source accepted a status update")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if err := source.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&posts); n != 0 {
+		t.Errorf("read-only source sent %d status updates", n)
+	}
+	if content, _ := os.ReadFile(cfg.StatusOutboxPath); !bytes.Equal(content, saved) {
+		t.Errorf("outbox file changed to %s", content)
+	}
+}
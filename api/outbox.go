@@ -0,0 +1,267 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_mailer/logger"
+	"go_mailer/scheduler"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Backoff between failed flushes of the status outbox
+const (
+	outboxRetryBaseDelay = 30 * time.Second
+	outboxRetryMaxDelay  = 30 * time.Minute
+)
+
+// statusOutbox queues status updates for the Google Sheet and sends them in
+// batches. The queue is saved to a file on every change, so an update that
+// could not be sent survives a restart, and it holds only the latest update
+// of each row.
+type statusOutbox struct {
+	path      string // Empty to keep the queue in memory only
+	batchSize int
+	interval  time.Duration
+	send      func(updates []rowStatusUpdate) error
+
+	mu        sync.Mutex
+	updates   []*outboxEntry // In the order they were queued
+	seq       int64
+	failures  int       // Consecutive failed flushes
+	nextFlush time.Time // No flush before this time after a failure
+
+	flushMu sync.Mutex // Held while a flush is in progress
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// outboxEntry is a queued update; seq tells a newer update of the same row
+// apart from the one being sent
+type outboxEntry struct {
+	Update rowStatusUpdate `json:"update"`
+	seq    int64
+}
+
+// openStatusOutbox loads the outbox at path ("memory" or empty for none) and
+// starts flushing it every interval, or only when a batch fills up if the
+// interval is zero
+func openStatusOutbox(path string, interval time.Duration, batchSize int, send func([]rowStatusUpdate) error) (*statusOutbox, error) {
+	o, err := loadStatusOutbox(path, interval, batchSize, send)
+	if err != nil {
+		return nil, err
+	}
+	go o.run()
+	return o, nil
+}
+
+// loadStatusOutbox loads the outbox at path without flushing it, for a look
+// at the queued updates. It must not be closed.
+func loadStatusOutbox(path string, interval time.Duration, batchSize int, send func([]rowStatusUpdate) error) (*statusOutbox, error) {
+	o := &statusOutbox{
+		batchSize: batchSize,
+		interval:  interval,
+		send:      send,
+		kick:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	if path != "memory" && path != "" {
+		o.path = path
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("error creating status outbox directory: %w", err)
+		}
+		if err := o.load(); err != nil {
+			return nil, err
+		}
+		if len(o.updates) > 0 {
+			logger.Info("📮 Loaded %d unsent status updates from %s", len(o.updates), path)
+		}
+	}
+
+	return o, nil
+}
+
+// load reads the saved queue
+func (o *statusOutbox) load() error {
+	content, err := os.ReadFile(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading status outbox: %w", err)
+	}
+	if len(content) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(content, &o.updates); err != nil {
+		return fmt.Errorf("error parsing status outbox %s: %w", o.path, err)
+	}
+	for _, entry := range o.updates {
+		o.seq++
+		entry.seq = o.seq
+	}
+	return nil
+}
+
+// save writes the queue to the outbox file. It must be called with o.mu held.
+func (o *statusOutbox) save() error {
+	if o.path == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(o.updates, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(o.path, append(content, '\n'))
+}
+
+// enqueue queues an update, replacing any queued update of the same row.
+// A full batch is sent straight away.
+func (o *statusOutbox) enqueue(update rowStatusUpdate) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.removeRow(update.RowID, -1)
+	o.seq++
+	o.updates = append(o.updates, &outboxEntry{Update: update, seq: o.seq})
+	if err := o.save(); err != nil {
+		return fmt.Errorf("error saving status outbox: %w", err)
+	}
+
+	if len(o.updates) >= o.batchSize {
+		select {
+		case o.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// removeRow drops the queued update of the row if it is no newer than
+// maxSeq, or whatever its age when maxSeq is negative. It must be called
+// with o.mu held.
+func (o *statusOutbox) removeRow(rowID string, maxSeq int64) {
+	for i, entry := range o.updates {
+		if entry.Update.RowID == rowID {
+			if maxSeq < 0 || entry.seq <= maxSeq {
+				o.updates = append(o.updates[:i], o.updates[i+1:]...)
+			}
+			return
+		}
+	}
+}
+
+// queued returns the queued update of the row, if any
+func (o *statusOutbox) queued(rowID string) (rowStatusUpdate, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, entry := range o.updates {
+		if entry.Update.RowID == rowID {
+			return entry.Update, true
+		}
+	}
+	return rowStatusUpdate{}, false
+}
+
+// run flushes the outbox every interval, and whenever a batch fills up,
+// until close is called
+func (o *statusOutbox) run() {
+	defer close(o.done)
+
+	var tick <-chan time.Time
+	if o.interval > 0 {
+		ticker := time.NewTicker(o.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-o.kick:
+		case <-o.stop:
+			return
+		}
+
+		o.mu.Lock()
+		wait := time.Until(o.nextFlush)
+		o.mu.Unlock()
+		if wait > 0 {
+			continue
+		}
+		o.flush()
+	}
+}
+
+// flush sends the queued updates in batches until the queue is empty or a
+// batch fails. After a failure the next flush is delayed with exponential
+// backoff.
+func (o *statusOutbox) flush() error {
+	o.flushMu.Lock()
+	defer o.flushMu.Unlock()
+
+	for {
+		o.mu.Lock()
+		n := len(o.updates)
+		if n > o.batchSize {
+			n = o.batchSize
+		}
+		batch := make([]outboxEntry, n)
+		for i, entry := range o.updates[:n] {
+			batch[i] = *entry
+		}
+		o.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+
+		updates := make([]rowStatusUpdate, n)
+		for i, entry := range batch {
+			updates[i] = entry.Update
+		}
+		err := o.send(updates)
+
+		o.mu.Lock()
+		if err != nil {
+			o.failures++
+			delay := scheduler.RetryDelay(o.failures, outboxRetryBaseDelay, outboxRetryMaxDelay)
+			o.nextFlush = time.Now().Add(delay)
+			queued := len(o.updates)
+			o.mu.Unlock()
+			logger.Error("❌ Failed to send %d status updates to the Google Sheet, %d queued, retrying in %v: %v",
+				n, queued, delay, err)
+			return err
+		}
+
+		// Keep the updates queued while this batch was being sent
+		for _, entry := range batch {
+			o.removeRow(entry.Update.RowID, entry.seq)
+		}
+		o.failures = 0
+		o.nextFlush = time.Time{}
+		saveErr := o.save()
+		o.mu.Unlock()
+
+		logger.Info("📮 Sent %d status updates to the Google Sheet", n)
+		if saveErr != nil {
+			logger.Error("❌ Failed to save status outbox: %v", saveErr)
+		}
+	}
+}
+
+// close stops the flush loop and makes a last attempt to send the queue.
+// Whatever is still queued stays in the outbox file for the next start.
+func (o *statusOutbox) close() error {
+	close(o.stop)
+	<-o.done
+	return o.flush()
+}
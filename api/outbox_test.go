@@ -0,0 +1,42 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutboxBacksOffAfterFailures(t *testing.T) {
+	outbox, err := loadStatusOutbox("memory", 0, 10, func([]rowStatusUpdate) error {
+		return errors.New("sheet unavailable")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.enqueue(rowStatusUpdate{RowID: "2", Status: "sent"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for failures := 1; failures <= 12; failures++ {
+		start := time.Now()
+		if err := outbox.flush(); err == nil {
+			t.Fatal("flush succeeded")
+		}
+		outbox.mu.Lock()
+		delay := outbox.nextFlush.Sub(start)
+		outbox.mu.Unlock()
+
+		// The delay doubles up to the maximum, with up to half of it jittered
+		max := outboxRetryMaxDelay
+		if failures <= 6 {
+			max = outboxRetryBaseDelay << (failures - 1)
+		}
+		if delay < max/2 || delay > max+time.Second {
+			t.Errorf("delay after %d failures = %v, want between %v and %v", failures, delay, max/2, max)
+		}
+	}
+
+	if _, ok := outbox.queued("2"); !ok {
+		t.Error("failed update was dropped from the outbox")
+	}
+}
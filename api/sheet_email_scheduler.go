@@ -1,8 +1,10 @@
 package api
 
 import (
+	"fmt"
 	"go_mailer/config"
 	"go_mailer/logger"
+	"go_mailer/mailer"
 	"go_mailer/scheduler"
 	"go_mailer/template"
 	"strings"
@@ -12,186 +14,287 @@ import (
 // EmailCompletionCallback is a function that gets called when an email is sent successfully
 type EmailCompletionCallback func(jobID string, email string) error
 
-// getTemplatePath returns the path to the email template based on the template name
-func getTemplatePath(templateName string) string {
+// getTemplatePath returns the path to the email template based on the
+// template name, or an error if no template has that name
+func getTemplatePath(templateName string) (string, error) {
 	// Print raw template name for debugging
 	logger.Debug("🔍 Raw template name from API: '%s'", templateName)
 
-	// Default to the standard template if no template is specified
-	if templateName == "" {
-		logger.Warning("⚠️ Empty template name, using default template")
-		return template.DefaultEmailTemplate
+	// The registry treats an empty name as the default template and matches
+	// names case-insensitively
+	selectedTemplate, err := template.DefaultRegistry().Path(templateName)
+	if err != nil {
+		return "", err
 	}
 
-	// Convert to lowercase and trim spaces for case-insensitive matching
-	templateNameLower := strings.ToLower(strings.TrimSpace(templateName))
-	logger.Debug("🔄 Processed template name: '%s'", templateNameLower)
+	logger.Debug("✅ Selected template path: %s", selectedTemplate)
+	return selectedTemplate, nil
+}
 
-	var selectedTemplate string
+// getAttachments resolves the attachment set names from a sheet row into the
+// files configured for them in ATTACHMENT_SETS
+func getAttachments(setNames string, cfg *config.Config) ([]mailer.Attachment, error) {
+	var attachments []mailer.Attachment
+	seen := make(map[string]bool)
 
-	switch templateNameLower {
-	case "normal":
-		selectedTemplate = template.DefaultEmailTemplate
-	case "casual":
-		selectedTemplate = template.CasualEmailTemplate
-	case "minimal":
-		selectedTemplate = template.MinimalEmailTemplate
-	default:
-		logger.Warning("⚠️ Unknown template name: '%s', using default template", templateNameLower)
-		selectedTemplate = template.DefaultEmailTemplate
+	for _, name := range strings.Split(setNames, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		paths, ok := cfg.AttachmentSets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown attachment set '%s'", name)
+		}
+		for _, path := range paths {
+			// Sets may share files; attach each one only once
+			if !seen[path] {
+				seen[path] = true
+				attachments = append(attachments, mailer.Attachment{Path: path})
+			}
+		}
 	}
 
-	logger.Debug("✅ Selected template path: %s", selectedTemplate)
-	return selectedTemplate
+	return attachments, nil
+}
+
+// templateGlobals returns the template variables shared by every email: the
+// sender's details and the variables configured in TEMPLATE_VARS
+func templateGlobals(cfg *config.Config) template.TemplateData {
+	globals := template.TemplateData{
+		"SenderName":  cfg.SenderName,
+		"SenderEmail": cfg.SenderEmail,
+	}
+	for name, value := range cfg.TemplateVars {
+		globals[name] = value
+	}
+	return globals
 }
 
-// ScheduleEmailsFromGoogleSheet fetches data from Google Sheet and schedules emails for entries
-// where SendStatus is false
-func ScheduleEmailsFromGoogleSheet(emailScheduler *scheduler.Scheduler, cfg *config.Config) error {
-	// Fetch data from Google Sheet API
-	logger.Info("🔄 Fetching data from Google Sheet API...")
-	response, err := FetchGoogleSheetData(cfg)
+// recordTemplateData layers the template data of a sheet row: globals, then
+// the row's extra columns, then the fixed fields, so that a stray column
+// cannot shadow them
+func recordTemplateData(record SheetData, globals template.TemplateData) template.TemplateData {
+	return template.Merge(globals, template.TemplateData(record.Extra), template.TemplateData{
+		"RecipientName":   record.EmployeeName,
+		"CompanyName":     record.CompanyName,
+		"ApplyingForRoll": record.Roll,
+	})
+}
+
+// SampleTemplateData returns the data every sheet row provides, with
+// placeholder values for the per-row fields, for checking templates
+// without a sheet
+func SampleTemplateData(cfg *config.Config) template.TemplateData {
+	return recordTemplateData(SheetData{
+		EmployeeName: "[RecipientName]",
+		CompanyName:  "[CompanyName]",
+		Roll:         "[ApplyingForRoll]",
+	}, templateGlobals(cfg))
+}
+
+// recordJob builds the email job for a sheet row, resolving its template,
+// attachment sets, template data and send time
+func recordJob(record SheetData, globals template.TemplateData, cfg *config.Config) (*scheduler.EmailJob, error) {
+	data := recordTemplateData(record, globals)
+
+	// Get IST location
+	ist := time.FixedZone("IST", 5*60*60+30*60)
+
+	// Convert SendAtDate to IST
+	sendAtDateIST := record.SendAtDate.In(ist)
+	year, month, day := sendAtDateIST.Date()
+
+	// Extract time components from SendAtTime
+	hour, min, sec := record.SendAtTime.Clock()
+
+	// Validate if SendAtTime is not the default value (1899-12-30)
+	if record.SendAtTime.Year() == 1899 && record.SendAtTime.Month() == 12 && record.SendAtTime.Day() == 30 {
+		// Use default time of 00:00:00 if SendAtTime is default
+		hour, min, sec = 0, 0, 0
+	}
+
+	// Create the combined time in IST
+	combinedSendTime := time.Date(year, month, day, hour, min, sec, 0, ist)
+
+	// Determine when to send the email
+	var sendTime time.Time
+	if time.Now().In(ist).After(combinedSendTime) {
+		// If combined time is in the past, schedule for immediate sending (1 minute from now)
+		sendTime = time.Now().In(ist).Add(time.Minute)
+		logger.Info("⏱️ Send time for %s is in the past (%s), rescheduling to %s", record.Email, combinedSendTime.Format("2006-01-02 15:04:05 MST"), sendTime.Format("2006-01-02 15:04:05 MST"))
+	} else {
+		sendTime = combinedSendTime
+	}
+
+	// Get the appropriate template path based on the template name in the record
+	templatePath, err := getTemplatePath(record.TemplateName)
 	if err != nil {
-		logger.Error("❌ Error fetching data from Google Sheet API: %v", err)
-		return err
+		return nil, err
 	}
+	logger.Debug("📄 Using template: %s for email to %s", templatePath, record.Email)
 
-	// Check if the request was successful
-	if response.Status != "success" {
-		logger.Warning("⚠️ Google Sheet API returned non-success status: %s", response.Status)
-		return nil
+	attachments, err := getAttachments(record.Attachments, cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	logger.Info("✅ Successfully fetched %d records from Google Sheet", len(response.Data))
+	return &scheduler.EmailJob{
+		RowID:        record.RowID,
+		To:           record.Email,
+		Cc:           record.Cc,
+		ReplyTo:      record.ReplyTo,
+		Subject:      strings.TrimSpace(record.Subject),
+		TemplatePath: templatePath,
+		TemplateData: data,
+		Attachments:  attachments,
+		SendAt:       sendTime,
+	}, nil
+}
 
-	// Get all pending jobs to check if emails are already scheduled
+// RowJob fetches the pending rows of a recipient source and builds the
+// email job for one of them without scheduling it. Rows are numbered from 1.
+func RowJob(source RecipientSource, cfg *config.Config, row int) (*scheduler.EmailJob, error) {
+	rows, err := source.FetchPending()
+	if err != nil {
+		return nil, err
+	}
+	if row < 1 || row > len(rows) {
+		return nil, fmt.Errorf("row %d does not exist, %s has %d unsent rows", row, source.Name(), len(rows))
+	}
+
+	return recordJob(rows[row-1], templateGlobals(cfg), cfg)
+}
+
+// ScheduleEmailsFromSource fetches the unsent rows of a recipient source and
+// schedules an email for each one that is not already pending. Set the
+// scheduler's status hook to SourceStatusHook to write the outcome of every
+// send back to the source.
+func ScheduleEmailsFromSource(emailScheduler *scheduler.Scheduler, source RecipientSource, cfg *config.Config) error {
+	logger.Info("🔄 Fetching recipients from %s...", source.Name())
+	records, err := source.FetchPending()
+	if err != nil {
+		logger.Error("❌ Error fetching recipients from %s: %v", source.Name(), err)
+		return err
+	}
+
+	logger.Info("✅ Successfully fetched %d unsent records from %s", len(records), source.Name())
+
+	// Get all unresolved jobs to check if rows are already scheduled, being
+	// sent, or were interrupted mid-send and may have been delivered
 	allJobs := emailScheduler.ListJobs()
-	pendingEmails := make(map[string]bool)
+	pendingRowIDs := make(map[string]bool)
 
-	// Populate pendingEmails map with emails that are already scheduled but not sent
+	// Populate the map with the rows whose email is not resolved
 	pendingCount := 0
 	for _, job := range allJobs {
-		if job.Status == "pending" {
-			pendingEmails[job.To] = true
+		if job.Unresolved() && job.RowID != "" {
+			pendingRowIDs[job.RowID] = true
 			pendingCount++
 		}
 	}
-	logger.Info("ℹ️ Found %d emails already scheduled and pending", pendingCount)
+	logger.Info("ℹ️ Found %d emails already scheduled, in progress or interrupted", pendingCount)
+
+	globals := templateGlobals(cfg)
 
 	// Track stats for logging
-	skippedSent := 0
 	skippedPending := 0
 	scheduled := 0
 
 	// Process each record
-	for _, record := range response.Data {
+	for _, record := range records {
 		// Log the raw record for debugging
 		logger.Debug("🔍 Processing record: %+v", record)
 
-		// Skip if SendStatus is true (already sent)
-		if record.SendStatus {
-			skippedSent++
+		// Without an ID the row cannot be told apart from others, nor its
+		// status written back
+		if record.RowID == "" {
+			logger.Error("❌ Skipping %s (%s at %s) - the row has no RowID", record.Email, record.EmployeeName, record.CompanyName)
 			continue
 		}
 
-		// Skip if email is already scheduled and pending
-		if pendingEmails[record.Email] {
-			logger.Info("⏭️ Skipping %s (%s at %s) - already scheduled and pending",
-				record.Email, record.EmployeeName, record.CompanyName)
+		// Skip if the row is already scheduled and pending
+		if pendingRowIDs[record.RowID] {
+			logger.Info("⏭️ Skipping row %s for %s (%s at %s) - already scheduled, in progress or interrupted",
+				record.RowID, record.Email, record.EmployeeName, record.CompanyName)
 			skippedPending++
 			continue
 		}
 
-		// Create template data for the email with the updated structure
-		data := template.TemplateData{
-			RecipientName:   record.EmployeeName,
-			CompanyName:     record.CompanyName,
-			ApplyingForRoll: record.Roll,
-		}
-
-		// Get IST location
-		ist := time.FixedZone("IST", 5*60*60+30*60)
-
-		// Convert SendAtDate to IST
-		sendAtDateIST := record.SendAtDate.In(ist)
-		year, month, day := sendAtDateIST.Date()
-
-		// Extract time components from SendAtTime
-		hour, min, sec := record.SendAtTime.Clock()
-
-		// Validate if SendAtTime is not the default value (1899-12-30)
-		if record.SendAtTime.Year() == 1899 && record.SendAtTime.Month() == 12 && record.SendAtTime.Day() == 30 {
-			// Use default time of 00:00:00 if SendAtTime is default
-			hour, min, sec = 0, 0, 0
+		job, err := recordJob(record, globals, cfg)
+		if err != nil {
+			logger.Error("❌ Skipping %s: %v", record.Email, err)
+			continue
 		}
 
-		// Create the combined time in IST
-		combinedSendTime := time.Date(year, month, day, hour, min, sec, 0, ist)
-
-		// Determine when to send the email
-		var sendTime time.Time
-		if time.Now().In(ist).After(combinedSendTime) {
-			// If combined time is in the past, schedule for immediate sending (1 minute from now)
-			sendTime = time.Now().In(ist).Add(time.Minute)
-			logger.Info("⏱️ Send time for %s is in the past (%s), rescheduling to %s", record.Email, combinedSendTime.Format("2006-01-02 15:04:05 MST"), sendTime.Format("2006-01-02 15:04:05 MST"))
+		// Schedule the email; without a Subject column the template's subject is used
+		if _, err := emailScheduler.ScheduleJob(job); err != nil {
+			logger.Error("❌ Failed to schedule email to %s: %v", job.To, err)
 		} else {
-			sendTime = combinedSendTime
-		}
-
-		// Get the appropriate template path based on the template name in the record
-		templatePath := getTemplatePath(record.TemplateName)
-		logger.Debug("📄 Using template: %s for email to %s", templatePath, record.Email)
-
-		// Schedule the email
-		subject := "Regarding " + record.Roll + " Position at " + record.CompanyName
-		jobID := scheduleEmailWithCallback(emailScheduler, record.Email, subject, templatePath, data, sendTime, cfg)
-		if jobID != "" {
 			scheduled++
-			logger.Info("📅 Scheduled email to %s (%s) at %s IST - Subject: %s", record.Email, record.EmployeeName, sendTime, subject)
+			subject := job.Subject
+			if subject == "" {
+				subject = "(from template)"
+			}
+			logger.Info("📅 Scheduled email to %s (%s) at %s IST - Subject: %s", record.Email, record.EmployeeName, job.SendAt, subject)
 		}
 
-		// Mark this email as pending to avoid scheduling it again in this batch
-		pendingEmails[record.Email] = true
+		// Mark this row as pending to avoid scheduling it again in this batch
+		pendingRowIDs[record.RowID] = true
 	}
 
 	// Summary log
-	logger.Info("📊 Summary: %d records processed, %d scheduled, %d skipped (already sent), %d skipped (already pending)", len(response.Data), scheduled, skippedSent, skippedPending)
+	logger.Info("📊 Summary: %d records processed, %d scheduled, %d skipped (already pending)", len(records), scheduled, skippedPending)
 
 	return nil
 }
 
-// scheduleEmailWithCallback schedules an email and sets up a callback function
-// that will be called when the email is sent successfully
-func scheduleEmailWithCallback(
-	s *scheduler.Scheduler,
-	to, subject, templatePath string,
-	data template.TemplateData,
-	sendTime time.Time,
-	cfg *config.Config,
-) string {
-	// Schedule the email
-	jobID, err := s.ScheduleEmail(to, subject, templatePath, data, sendTime)
+// SourceStatusHook returns a scheduler status hook that writes the status of
+// every job built from a row of the source back to that row
+func SourceStatusHook(source RecipientSource) scheduler.StatusHook {
+	return func(job scheduler.EmailJob) {
+		if job.RowID == "" {
+			// Not built from a recipient source
+			return
+		}
+		status, ok := jobRowStatus(job)
+		if !ok {
+			return
+		}
 
-	if err != nil {
-		logger.Error("❌ Failed to schedule email to %s: %v", to, err)
-		return ""
-	}
-
-	// Register the callback function
-	s.RegisterCallback(jobID, func(successful bool) {
-		if successful {
-			// If email was sent successfully, update the Google Sheet
-			logger.Info("✉️ Email sent successfully to %s, updating Google Sheet...", to)
-			err := UpdateSendStatus(to, true, cfg)
-			if err != nil {
-				logger.Error("❌ Failed to update send status for %s: %v", to, err)
-			} else {
-				logger.Info("✅ Successfully updated send status for %s in Google Sheet", to)
-			}
-		} else {
-			logger.Error("❌ Email to %s failed to send", to)
+		if err := source.UpdateStatus(job.RowID, status); err != nil {
+			logger.Error("❌ Failed to update row %s (%s) in %s to %s: %v", job.RowID, job.To, source.Name(), status.Status, err)
+			return
 		}
-	})
+		logger.Info("✅ Recorded %s for row %s (%s) in %s", status.Status, job.RowID, job.To, source.Name())
+	}
+}
 
-	return jobID
+// jobRowStatus converts a job's state into the status written to its row.
+// Jobs that are being sent have no row status.
+func jobRowStatus(job scheduler.EmailJob) (RowStatus, bool) {
+	status := RowStatus{
+		SentAt:    job.SentAt,
+		MessageID: job.MessageID,
+		Attempts:  job.Attempts,
+		Error:     job.ErrorMessage,
+	}
+	switch job.Status {
+	case scheduler.StatusPending:
+		status.Status = RowScheduled
+	case scheduler.StatusSent:
+		status.Status = RowSent
+	case scheduler.StatusFailed:
+		status.Status = RowFailed
+	case scheduler.StatusBounced:
+		status.Status = RowBounced
+	case scheduler.StatusCancelled:
+		status.Status = RowCancelled
+	case scheduler.StatusInterrupted:
+		status.Status = RowInterrupted
+	default:
+		return status, false
+	}
+	return status, true
 }
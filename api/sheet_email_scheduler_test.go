@@ -0,0 +1,73 @@
+package api
+
+import (
+	"go_mailer/config"
+	"go_mailer/scheduler"
+	"go_mailer/template"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// staticSource is a recipient source with fixed rows that records status updates
+type staticSource struct {
+	rows    []SheetData
+	updates map[string]string
+}
+
+func (s *staticSource) Name() string                       { return "static source" }
+func (s *staticSource) FetchPending() ([]SheetData, error) { return s.rows, nil }
+func (s *staticSource) UpdateStatus(rowID string, status RowStatus) error {
+	s.updates[rowID] = status.Status
+	return nil
+}
+
+func TestScheduleEmailsFromSourceSkipsRowsWithoutID(t *testing.T) {
+	dir := t.TempDir()
+	page := "<!--\nsubject: Hello\n-->\n<p>Hi {{.RecipientName}}</p>"
+	if err := os.WriteFile(filepath.Join(dir, "email_template.html"), []byte(page), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	templates := template.NewRegistry(dir)
+	if err := templates.Load(); err != nil {
+		t.Fatal(err)
+	}
+	template.SetDefaultRegistry(templates)
+
+	cfg := &config.Config{}
+	s, err := scheduler.NewWithStore(cfg, scheduler.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &staticSource{
+		rows: []SheetData{
+			{Email: "ann@example.com", EmployeeName: "Ann"},
+			{RowID: "r1", Email: "bob@example.com", EmployeeName: "Bob"},
+			{Email: "carol@example.com", EmployeeName: "Carol"},
+			{RowID: "r2", Email: "bob@example.com", EmployeeName: "Bob"},
+		},
+		updates: make(map[string]string),
+	}
+	s.SetStatusHook(SourceStatusHook(source))
+
+	if err := ScheduleEmailsFromSource(s, source, cfg); err != nil {
+		t.Fatal(err)
+	}
+	jobs := s.ListJobs()
+	if len(jobs) != 2 {
+		t.Fatalf("scheduled %d jobs, want one for each row with an ID", len(jobs))
+	}
+	for _, id := range []string{"r1", "r2"} {
+		if source.updates[id] != RowScheduled {
+			t.Errorf("row %s is %q, want %s", id, source.updates[id], RowScheduled)
+		}
+	}
+
+	// The rows are pending now, so a second check schedules nothing
+	if err := ScheduleEmailsFromSource(s, source, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.ListJobs()); n != 2 {
+		t.Errorf("second check left %d jobs, want 2", n)
+	}
+}
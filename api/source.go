@@ -0,0 +1,223 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"go_mailer/config"
+	"strings"
+	"time"
+)
+
+// RecipientSource is where the scheduler reads the rows to email and where
+// it records the outcome of each send. The Google Sheet is one source;
+// local CSV, JSON and SQL sources let the same pipeline run without one.
+// Rows are identified by their RowID rather than their address, so the same
+// contact can appear in several rows.
+type RecipientSource interface {
+	// Name describes the source in log messages
+	Name() string
+	// FetchPending returns the rows that have not been sent yet, each with
+	// its RowID set
+	FetchPending() ([]SheetData, error)
+	// UpdateStatus records the state of the row's email
+	UpdateStatus(rowID string, status RowStatus) error
+}
+
+// Row statuses written back to a recipient source
+const (
+	RowScheduled = "scheduled"
+	RowSent      = "sent"
+	RowFailed    = "failed" // Failed for good; the row is scheduled again on the next check
+	RowBounced   = "bounced"
+	RowCancelled = "cancelled"
+	// RowInterrupted means the process stopped mid-send, so the email may or
+	// may not have arrived; it is not sent again automatically
+	RowInterrupted = "interrupted"
+)
+
+// RowStatus is the state of a row's email as written back to its source
+type RowStatus struct {
+	Status    string    // One of the Row status constants
+	SentAt    time.Time // Zero until the email is sent
+	MessageID string    // Message-ID header of the sent email
+	Attempts  int       // Send attempts made so far
+	Error     string    // Last send error, empty once sent
+}
+
+// statusColumns are the columns a source writes the RowStatus to. They are
+// kept out of the template data.
+var statusColumns = map[string]bool{
+	"Status": true, "SentAt": true, "MessageID": true, "Attempts": true, "SendError": true,
+}
+
+// Recipient source kinds, selected with RECIPIENT_SOURCE
+const (
+	SourceGoogleSheet = "sheet"
+	SourceCSV         = "csv"
+	SourceJSON        = "json" // A JSON array of rows, or one JSON object per line
+	SourceSQL         = "sqlite"
+)
+
+// NewRecipientSource creates the recipient source selected in the configuration
+func NewRecipientSource(cfg *config.Config) (RecipientSource, error) {
+	switch cfg.RecipientSource {
+	case SourceGoogleSheet, "":
+		return NewGoogleSheetSource(cfg)
+	case SourceCSV:
+		return NewCSVSource(cfg.RecipientFile), nil
+	case SourceJSON, "ndjson":
+		return NewJSONSource(cfg.RecipientFile), nil
+	case SourceSQL:
+		return NewSQLSource(cfg.RecipientSQLDriver, cfg.RecipientSQLDSN, cfg.RecipientSQLTable)
+	default:
+		return nil, fmt.Errorf("unknown recipient source %q", cfg.RecipientSource)
+	}
+}
+
+// NewReadOnlyRecipientSource creates the configured recipient source for
+// reading rows, e.g. to preview one. Nothing is started in the background;
+// in particular the Google Sheet status outbox is not flushed.
+func NewReadOnlyRecipientSource(cfg *config.Config) (RecipientSource, error) {
+	if cfg.RecipientSource == SourceGoogleSheet || cfg.RecipientSource == "" {
+		return NewReadOnlyGoogleSheetSource(cfg)
+	}
+	return NewRecipientSource(cfg)
+}
+
+// pendingRows drops the rows that were already sent, bounced, were
+// cancelled or interrupted. Failed rows are kept so that they are tried again.
+func pendingRows(rows []SheetData) []SheetData {
+	pending := rows[:0]
+	for _, row := range rows {
+		switch strings.ToLower(strings.TrimSpace(row.Status)) {
+		case RowSent, RowBounced, RowCancelled, RowInterrupted:
+			continue
+		}
+		if !row.SendStatus {
+			pending = append(pending, row)
+		}
+	}
+	return pending
+}
+
+// newRowID returns a random (version 4) UUID for a row that has no ID
+func newRowID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]), nil
+}
+
+// dateLayouts are the formats accepted for SendAtDate in local sources
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"02/01/2006",
+}
+
+// timeLayouts are the formats accepted for SendAtTime in local sources,
+// read as IST wall-clock times
+var timeLayouts = []string{
+	time.RFC3339,
+	"15:04",
+	"15:04:05",
+	"3:04 PM",
+	"3:04PM",
+}
+
+// decodeRow converts a row read from a local source into SheetData. Values
+// are coerced the way the Apps Script would send them: SendStatus accepts
+// true/false, yes/no and 1/0, and dates and times accept common layouts.
+// Unknown columns end up in Extra.
+func decodeRow(columns map[string]interface{}) (SheetData, error) {
+	var row SheetData
+
+	normalized := make(map[string]interface{}, len(columns))
+	for column, value := range columns {
+		normalized[column] = value
+	}
+
+	if value, ok := normalized["SendStatus"]; ok {
+		sent, err := parseSendStatus(value)
+		if err != nil {
+			return row, err
+		}
+		normalized["SendStatus"] = sent
+	}
+	for column, layouts := range map[string][]string{"SendAtDate": dateLayouts, "SendAtTime": timeLayouts} {
+		value, ok := normalized[column]
+		if _, isTime := value.(time.Time); !ok || isTime {
+			continue
+		}
+		text := strings.TrimSpace(fmt.Sprint(value))
+		if value == nil || text == "" {
+			delete(normalized, column)
+			continue
+		}
+		t, err := parseLayouts(text, layouts)
+		if err != nil {
+			return row, fmt.Errorf("invalid %s %q", column, text)
+		}
+		normalized[column] = t
+	}
+	// Every other fixed column is text
+	for column := range sheetDataColumns {
+		if value, ok := normalized[column]; ok && column != "SendStatus" && column != "SendAtDate" && column != "SendAtTime" {
+			if value == nil {
+				delete(normalized, column)
+			} else if _, isString := value.(string); !isString {
+				normalized[column] = fmt.Sprint(value)
+			}
+		}
+	}
+
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return row, err
+	}
+	if err := json.Unmarshal(data, &row); err != nil {
+		return row, err
+	}
+	return row, nil
+}
+
+// parseSendStatus reads a send status cell
+func parseSendStatus(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	case float64:
+		return v != 0, nil
+	case int64:
+		return v != 0, nil
+	case json.Number:
+		return v.String() != "0", nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "", "false", "no", "0":
+			return false, nil
+		case "true", "yes", "1", "sent":
+			return true, nil
+		}
+		return false, fmt.Errorf("invalid SendStatus %q", v)
+	}
+	return false, fmt.Errorf("invalid SendStatus %v", value)
+}
+
+// parseLayouts parses value with the first layout that fits
+func parseLayouts(value string, layouts []string) (time.Time, error) {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised format %q", value)
+}
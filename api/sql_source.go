@@ -0,0 +1,196 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"go_mailer/logger"
+	"regexp"
+	"strings"
+	"time"
+
+	// Pure-Go SQLite driver, registered as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// sqlIdentifier matches table names that are safe to put into a query
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLSource reads recipients from a SQLite table through database/sql. The
+// table has a column per Google Sheet column, including SendStatus (0 or 1),
+// and any extra columns become template fields. The status is written to
+// whichever of the Status, SentAt, MessageID, Attempts and SendError columns
+// the table has. Rows are identified by SQLite's rowid, or by a RowID column
+// if the table declares one; the queries rely on both, so other databases are
+// not supported. A pure-Go driver is built in, and RECIPIENT_SQL_DRIVER can
+// select another SQLite driver that is linked in with a blank import.
+type SQLSource struct {
+	db      *sql.DB
+	table   string
+	columns []string // The table's columns, for choosing what UpdateStatus writes
+}
+
+// sqliteDrivers are the names SQLite drivers register with database/sql
+var sqliteDrivers = []string{"sqlite", "sqlite3"}
+
+// NewSQLSource opens the SQLite database at dsn with the named driver
+func NewSQLSource(driver, dsn, table string) (*SQLSource, error) {
+	if !sqlIdentifier.MatchString(table) {
+		return nil, fmt.Errorf("invalid recipient table name %q", table)
+	}
+	if !containsString(sqliteDrivers, driver) {
+		return nil, fmt.Errorf("database driver %q is not supported, the recipient table must be in SQLite", driver)
+	}
+	if !containsString(sql.Drivers(), driver) {
+		return nil, fmt.Errorf("no %q database driver is linked into this build; the built-in driver is \"sqlite\"", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening recipient database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error opening recipient database: %w", err)
+	}
+
+	rows, err := db.Query("SELECT * FROM " + table + " LIMIT 0")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error reading recipient table: %w", err)
+	}
+	columns, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error reading recipient table: %w", err)
+	}
+	if !containsFold(columns, "SendStatus") {
+		db.Close()
+		return nil, fmt.Errorf("recipient table %s has no SendStatus column", table)
+	}
+
+	return &SQLSource{db: db, table: table, columns: columns}, nil
+}
+
+// Name describes the source in log messages
+func (s *SQLSource) Name() string {
+	return "database table " + s.table
+}
+
+// Close closes the database
+func (s *SQLSource) Close() error {
+	return s.db.Close()
+}
+
+// unsentCondition selects the rows that have not been sent
+const unsentCondition = "(SendStatus IS NULL OR SendStatus = 0 OR SendStatus = '' OR LOWER(SendStatus) = 'false')"
+
+// FetchPending returns the rows of the table that have not been sent yet
+func (s *SQLSource) FetchPending() ([]SheetData, error) {
+	rows, err := s.db.Query("SELECT rowid AS RowID, * FROM " + s.table + " WHERE " + unsentCondition)
+	if err != nil {
+		return nil, fmt.Errorf("error querying recipients: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []SheetData
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("error reading recipients: %w", err)
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			switch value := values[i].(type) {
+			case []byte:
+				row[column] = string(value)
+			case time.Time:
+				row[column] = value.Format(time.RFC3339)
+			default:
+				row[column] = value
+			}
+		}
+
+		record, err := decodeRow(row)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding recipient %v: %w", row["Email"], err)
+		}
+		if record.RowID == "" {
+			// A declared RowID column replaces SQLite's rowid, so there is
+			// no other way to address the row
+			logger.Error("❌ Skipping %v in %s: its RowID is empty", row["Email"], s.table)
+			continue
+		}
+		pending = append(pending, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading recipients: %w", err)
+	}
+
+	return pendingRows(pending), nil
+}
+
+// UpdateStatus writes the row's status to SendStatus and to the other status
+// columns the table has
+func (s *SQLSource) UpdateStatus(rowID string, status RowStatus) error {
+	sentAt := ""
+	if !status.SentAt.IsZero() {
+		sentAt = status.SentAt.Format(time.RFC3339)
+	}
+	sent := 0
+	if status.Status == RowSent {
+		sent = 1
+	}
+
+	values := []struct {
+		column string
+		value  interface{}
+	}{
+		{"SendStatus", sent},
+		{"Status", status.Status},
+		{"SentAt", sentAt},
+		{"MessageID", status.MessageID},
+		{"Attempts", status.Attempts},
+		{"SendError", status.Error},
+	}
+
+	var set []string
+	var args []interface{}
+	for _, v := range values {
+		if containsFold(s.columns, v.column) {
+			set = append(set, v.column+" = ?")
+			args = append(args, v.value)
+		}
+	}
+
+	query := "UPDATE " + s.table + " SET " + strings.Join(set, ", ") + " WHERE rowid = ?"
+	result, err := s.db.Exec(query, append(args, rowID)...)
+	if err != nil {
+		return fmt.Errorf("error updating recipient row %s: %w", rowID, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("no row with ID %s in %s", rowID, s.table)
+	}
+	return nil
+}
+
+// containsFold reports whether list contains s, ignoring case as SQL does
+// for column names
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
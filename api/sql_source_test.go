@@ -0,0 +1,55 @@
+package api
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLSourceOnlyOpensSQLite(t *testing.T) {
+	for _, driver := range []string{"postgres", "mysql"} {
+		if _, err := NewSQLSource(driver, "recipients.db", "recipients"); err == nil {
+			t.Errorf("driver %s accepted", driver)
+		}
+	}
+}
+
+func TestSQLSourceSkipsRowsWithoutID(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "recipients.db")
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE recipients (RowID TEXT, Email TEXT, SendStatus INTEGER, Status TEXT)",
+		"INSERT INTO recipients VALUES ('a1', 'ann@example.com', 0, ''), (NULL, 'bob@example.com', 0, ''), ('', 'carol@example.com', 0, ''), ('d1', 'dave@example.com', 0, '')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source, err := NewSQLSource("sqlite", dsn, "recipients")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	rows, err := source.FetchPending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].RowID != "a1" || rows[1].RowID != "d1" {
+		t.Fatalf("pending rows %+v, want a1 and d1", rows)
+	}
+
+	// The declared column is what status updates address
+	if err := source.UpdateStatus("d1", RowStatus{Status: RowSent}); err != nil {
+		t.Fatal(err)
+	}
+	var email string
+	if err := db.QueryRow("SELECT Email FROM recipients WHERE Status = 'sent'").Scan(&email); err != nil || email != "dave@example.com" {
+		t.Errorf("sent row is %q (%v), want dave@example.com", email, err)
+	}
+}
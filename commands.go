@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go_mailer/config"
+	"go_mailer/template"
+	"os"
+)
+
+// commands are the subcommands run instead of the service, e.g.
+// "go_mailer lint". Each returns the process exit code.
+var commands = map[string]func(args []string) int{
+	"lint":    runLint,
+	"preview": runPreview,
+	"resolve": runResolve,
+}
+
+// loadCommandConfig loads the configuration for a subcommand. Subcommands
+// only render templates, so an incomplete configuration, such as one
+// without SMTP credentials, falls back to the template settings alone.
+func loadCommandConfig() *config.Config {
+	cfg, err := config.Load()
+	if err == nil {
+		return cfg
+	}
+
+	fmt.Fprintf(os.Stderr, "⚠️ Configuration incomplete (%v), using defaults\n", err)
+	cfg = &config.Config{
+		SenderEmail: os.Getenv("SENDER_MAIL_ID"),
+		SenderName:  os.Getenv("SENDER_NAME"),
+		TemplateDir: os.Getenv("TEMPLATE_DIR"),
+		InlineCSS:   true,
+	}
+	if cfg.TemplateDir == "" {
+		cfg.TemplateDir = template.DefaultTemplateDir
+	}
+	return cfg
+}
+
+// readTemplateData reads a JSON object of template data from a file
+func readTemplateData(path string) (template.TemplateData, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template data: %w", err)
+	}
+
+	var data template.TemplateData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("error parsing template data %s: %w", path, err)
+	}
+	return data, nil
+}
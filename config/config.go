@@ -1,19 +1,112 @@
 package config
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net/mail"
 	"os"
+	"strings"
+	"time"
+)
+
+// SMTP TLS modes
+const (
+	TLSModeNone             = "none"              // Plain connection, never upgraded
+	TLSModeStartTLS         = "starttls"          // Upgrade with STARTTLS when the server offers it
+	TLSModeRequiredStartTLS = "required-starttls" // Refuse to send unless STARTTLS succeeds
+	TLSModeImplicit         = "implicit"          // TLS from the first byte, usually port 465
+)
+
+// SMTP authentication mechanisms
+const (
+	AuthAuto    = "auto"     // Best mechanism advertised by the server
+	AuthPlain   = "plain"    // AUTH PLAIN with SMTPUsername and Password
+	AuthLogin   = "login"    // AUTH LOGIN with SMTPUsername and Password
+	AuthCRAMMD5 = "cram-md5" // AUTH CRAM-MD5 with SMTPUsername and Password
+	AuthXOAuth2 = "xoauth2"  // AUTH XOAUTH2 with an OAuth 2.0 access token
+	AuthNone    = "none"     // Do not authenticate
 )
 
 // Config holds the application configuration
 type Config struct {
 	SenderEmail      string
+	SenderName       string // Optional display name shown in the From header
+	ReplyTo          string // Default Reply-To address list, used when a job sets none
+	Bcc              string // Address list blind-copied on every email, e.g. for a sent-copy
 	Password         string
 	SMTPHost         string
 	SMTPPort         string
 	GOOGEL_SHEET_API string
 	InputTimezone    string // Timezone for input times (e.g., "Asia/Kolkata")
 	ServerTimezone   string // Timezone where server is running (e.g., "Asia/Singapore")
+	JobStorePath     string // Path of the persistent job log ("memory" keeps jobs in memory only)
+
+	// HTTP client for the Google Sheet API
+	SheetAPITimeout         time.Duration // Deadline for a whole request, including reading the response
+	SheetAPIConnectTimeout  time.Duration // Deadline for connecting and the TLS handshake
+	SheetAPIMaxAttempts     int           // Attempts per request when the API fails with 5xx or 429
+	SheetAPIRetryDelay      time.Duration // Delay before the first retry; doubles on every attempt
+	SheetAPIToken           string        // Sent as "Authorization: Bearer <token>" when set
+	SheetAPISecret          string        // Shared secret sent in SheetAPISecretHeader when set
+	SheetAPISecretHeader    string
+	SheetAPIMaxResponseSize int64 // Responses larger than this many bytes are rejected
+
+	// SMTP connection reuse
+	SMTPPoolSize           int           // Maximum number of idle connections kept open
+	SMTPIdleTimeout        time.Duration // Idle connections older than this are closed
+	SMTPMaxMessagesPerConn int           // Connections are recycled after this many messages
+	SMTPTimeout            time.Duration // Deadline for connecting and for each message sent
+
+	// SMTP transport security
+	SMTPTLSMode       string // One of the TLSMode constants
+	SMTPMinTLSVersion uint16 // Minimum accepted TLS version, e.g. tls.VersionTLS12
+	SMTPCACertFile    string // PEM bundle of CAs to trust instead of the system pool
+	SMTPServerName    string // Name to verify in the server certificate, defaults to SMTPHost
+
+	// SMTP authentication
+	SMTPAuthMechanism  string // One of the Auth constants
+	SMTPUsername       string // Login name, defaults to SenderEmail
+	OAuth2ClientID     string
+	OAuth2ClientSecret string
+	OAuth2RefreshToken string
+	OAuth2TokenURL     string // Token endpoint used to refresh the access token
+
+	// Retry policy for transient send failures
+	MaxSendAttempts int           // Total attempts per email, including the first one
+	RetryBaseDelay  time.Duration // Delay before the first retry; doubles on every attempt
+	RetryMaxDelay   time.Duration // Upper bound for the delay between attempts
+
+	MaxConcurrentSends int // Maximum number of SMTP sessions open at the same time
+
+	// Send budgets for the sender account; 0, the default, disables a limit
+	RateLimitPerMinute     int
+	RateLimitPerHour       int
+	RateLimitPerDay        int
+	DomainRateLimitPerHour int // Maximum sends per hour to a single recipient domain
+
+	// Where recipients are read from and their send status written back
+	RecipientSource    string // "sheet", "csv", "json", "ndjson" or "sqlite"
+	RecipientFile      string // CSV or JSON file for the file sources
+	RecipientSQLDriver string // SQLite database/sql driver name for the sqlite source
+	RecipientSQLDSN    string // Database to open, e.g. the SQLite file path
+	RecipientSQLTable  string // Table holding one row per recipient
+
+	// Outbox of status updates waiting to be written to the Google Sheet
+	StatusOutboxPath    string        // Path of the outbox file ("memory" keeps it in memory only)
+	StatusFlushInterval time.Duration // How often queued updates are sent
+	StatusBatchSize     int           // Maximum updates per request; a full batch is sent at once
+
+	// Attachments
+	AttachmentSets    map[string][]string // Named lists of file paths, selected per sheet row
+	MaxAttachmentSize int64               // Maximum total size of an email's attachments in bytes
+
+	// Templates
+	TemplateDir            string            // Directory templates are discovered in
+	TemplateReloadInterval time.Duration     // How often to check templates for changes, 0 disables hot reload
+	TemplateVars           map[string]string // Global template variables available to every email
+	TemplateStrict         bool              // Fail rendering on fields missing from the data
+	InlineCSS              bool              // Copy <style> rules into style attributes
+	MinifyHTML             bool              // Minify the rendered HTML
 }
 
 // Load loads the configuration from environment variables
@@ -23,6 +116,7 @@ func Load() (*Config, error) {
 	smtpHost := os.Getenv("SMTP_HOST")
 	smtpPort := os.Getenv("SMTP_PORT")
 	googelSheetApi := os.Getenv("GOOGEL_SHEET_API")
+	jobStorePath := os.Getenv("JOB_STORE_PATH")
 
 	// Set defaults if not provided
 	if smtpHost == "" {
@@ -31,18 +125,290 @@ func Load() (*Config, error) {
 	if smtpPort == "" {
 		smtpPort = "587"
 	}
+	if jobStorePath == "" {
+		jobStorePath = "data/jobs.jsonl"
+	}
+
+	sheetAPITimeout, err := getEnvDuration("SHEET_API_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	sheetAPIConnectTimeout, err := getEnvDuration("SHEET_API_CONNECT_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	sheetAPIMaxAttempts, err := getEnvInt("SHEET_API_MAX_ATTEMPTS", 4)
+	if err != nil {
+		return nil, err
+	}
+	sheetAPIRetryDelay, err := getEnvDuration("SHEET_API_RETRY_DELAY", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	sheetAPISecretHeader := os.Getenv("SHEET_API_SECRET_HEADER")
+	if sheetAPISecretHeader == "" {
+		sheetAPISecretHeader = "X-Sheet-Secret"
+	}
+	sheetAPIMaxResponseMB, err := getEnvInt("SHEET_API_MAX_RESPONSE_MB", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSendAttempts, err := getEnvInt("MAX_SEND_ATTEMPTS", 5)
+	if err != nil {
+		return nil, err
+	}
+	retryBaseDelay, err := getEnvDuration("RETRY_BASE_DELAY", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	retryMaxDelay, err := getEnvDuration("RETRY_MAX_DELAY", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	maxConcurrentSends, err := getEnvInt("MAX_CONCURRENT_SENDS", 2)
+	if err != nil {
+		return nil, err
+	}
+	smtpPoolSize, err := getEnvInt("SMTP_POOL_SIZE", 2)
+	if err != nil {
+		return nil, err
+	}
+	smtpIdleTimeout, err := getEnvDuration("SMTP_IDLE_TIMEOUT", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	smtpMaxMessagesPerConn, err := getEnvInt("SMTP_MAX_MESSAGES_PER_CONN", 50)
+	if err != nil {
+		return nil, err
+	}
+	smtpTimeout, err := getEnvDuration("SMTP_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	smtpTLSMode := strings.ToLower(os.Getenv("SMTP_TLS_MODE"))
+	if smtpTLSMode == "" {
+		if smtpPort == "465" {
+			smtpTLSMode = TLSModeImplicit
+		} else {
+			smtpTLSMode = TLSModeStartTLS
+		}
+	}
+	switch smtpTLSMode {
+	case TLSModeNone, TLSModeStartTLS, TLSModeRequiredStartTLS, TLSModeImplicit:
+	default:
+		return nil, fmt.Errorf("SMTP_TLS_MODE must be one of none, starttls, required-starttls or implicit, got %q", smtpTLSMode)
+	}
+	smtpMinTLSVersion, err := parseTLSVersion(os.Getenv("SMTP_MIN_TLS_VERSION"))
+	if err != nil {
+		return nil, err
+	}
+	rateLimitPerMinute, err := getEnvInt("RATE_LIMIT_PER_MINUTE", 0)
+	if err != nil {
+		return nil, err
+	}
+	rateLimitPerHour, err := getEnvInt("RATE_LIMIT_PER_HOUR", 0)
+	if err != nil {
+		return nil, err
+	}
+	rateLimitPerDay, err := getEnvInt("RATE_LIMIT_PER_DAY", 0)
+	if err != nil {
+		return nil, err
+	}
+	domainRateLimitPerHour, err := getEnvInt("DOMAIN_RATE_LIMIT_PER_HOUR", 0)
+	if err != nil {
+		return nil, err
+	}
+	recipientSource := strings.ToLower(os.Getenv("RECIPIENT_SOURCE"))
+	if recipientSource == "" {
+		recipientSource = "sheet"
+	}
+	recipientFile := os.Getenv("RECIPIENT_FILE")
+	recipientSQLDriver := os.Getenv("RECIPIENT_SQL_DRIVER")
+	if recipientSQLDriver == "" {
+		recipientSQLDriver = "sqlite"
+	}
+	recipientSQLTable := os.Getenv("RECIPIENT_SQL_TABLE")
+	if recipientSQLTable == "" {
+		recipientSQLTable = "recipients"
+	}
+	switch recipientSource {
+	case "sheet", "sqlite":
+	case "csv", "json", "ndjson":
+		if recipientFile == "" {
+			return nil, fmt.Errorf("RECIPIENT_FILE must be set for RECIPIENT_SOURCE=%s", recipientSource)
+		}
+	default:
+		return nil, fmt.Errorf("RECIPIENT_SOURCE must be one of sheet, csv, json, ndjson or sqlite, got %q", recipientSource)
+	}
+	statusOutboxPath := os.Getenv("STATUS_OUTBOX_PATH")
+	if statusOutboxPath == "" {
+		statusOutboxPath = "data/status_outbox.json"
+	}
+	statusFlushInterval, err := getEnvDuration("STATUS_FLUSH_INTERVAL", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if statusFlushInterval <= 0 {
+		return nil, fmt.Errorf("STATUS_FLUSH_INTERVAL must be positive, got %v", statusFlushInterval)
+	}
+	statusBatchSize, err := getEnvInt("STATUS_BATCH_SIZE", 50)
+	if err != nil {
+		return nil, err
+	}
+	if statusBatchSize < 1 {
+		return nil, fmt.Errorf("STATUS_BATCH_SIZE must be at least 1, got %d", statusBatchSize)
+	}
+	attachmentSets, err := parseAttachmentSets(os.Getenv("ATTACHMENT_SETS"))
+	if err != nil {
+		return nil, err
+	}
+	templateDir := os.Getenv("TEMPLATE_DIR")
+	if templateDir == "" {
+		templateDir = "tamplets"
+	}
+	templateReloadInterval, err := getEnvDuration("TEMPLATE_RELOAD_INTERVAL", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	templateVars, err := parseTemplateVars(os.Getenv("TEMPLATE_VARS"))
+	if err != nil {
+		return nil, err
+	}
+	templateStrict, err := getEnvBool("TEMPLATE_STRICT", false)
+	if err != nil {
+		return nil, err
+	}
+	inlineCSS, err := getEnvBool("INLINE_CSS", true)
+	if err != nil {
+		return nil, err
+	}
+	minifyHTML, err := getEnvBool("MINIFY_HTML", false)
+	if err != nil {
+		return nil, err
+	}
+	maxAttachmentSizeMB, err := getEnvInt("MAX_ATTACHMENT_SIZE_MB", 18)
+	if err != nil {
+		return nil, err
+	}
+	if maxSendAttempts < 1 {
+		return nil, fmt.Errorf("MAX_SEND_ATTEMPTS must be at least 1")
+	}
+	if maxConcurrentSends < 1 {
+		return nil, fmt.Errorf("MAX_CONCURRENT_SENDS must be at least 1")
+	}
+
+	smtpAuthMechanism := strings.ToLower(os.Getenv("SMTP_AUTH_MECHANISM"))
+	if smtpAuthMechanism == "" {
+		smtpAuthMechanism = AuthAuto
+	}
+	switch smtpAuthMechanism {
+	case AuthAuto, AuthPlain, AuthLogin, AuthCRAMMD5, AuthXOAuth2, AuthNone:
+	default:
+		return nil, fmt.Errorf("SMTP_AUTH_MECHANISM must be one of auto, plain, login, cram-md5, xoauth2 or none, got %q", smtpAuthMechanism)
+	}
+	smtpUsername := os.Getenv("SMTP_USERNAME")
+	if smtpUsername == "" {
+		smtpUsername = senderEmail
+	}
+	oauth2TokenURL := os.Getenv("OAUTH2_TOKEN_URL")
+	if oauth2TokenURL == "" {
+		oauth2TokenURL = "https://oauth2.googleapis.com/token"
+	}
+	oauth2RefreshToken := os.Getenv("OAUTH2_REFRESH_TOKEN")
+
+	replyTo := os.Getenv("REPLY_TO")
+	if err := validateAddressList("REPLY_TO", replyTo); err != nil {
+		return nil, err
+	}
+	bcc := os.Getenv("BCC")
+	if err := validateAddressList("BCC", bcc); err != nil {
+		return nil, err
+	}
 
 	// Validate required fields
-	if senderEmail == "" || password == "" {
-		return nil, fmt.Errorf("SENDER_MAIL_ID and PASSWORD environment variables must be set")
+	if senderEmail == "" {
+		return nil, fmt.Errorf("SENDER_MAIL_ID environment variable must be set")
+	}
+	switch smtpAuthMechanism {
+	case AuthXOAuth2:
+		if oauth2RefreshToken == "" {
+			return nil, fmt.Errorf("OAUTH2_REFRESH_TOKEN must be set when SMTP_AUTH_MECHANISM is xoauth2")
+		}
+	case AuthNone:
+	default:
+		if password == "" && oauth2RefreshToken == "" {
+			return nil, fmt.Errorf("PASSWORD or OAUTH2_REFRESH_TOKEN environment variable must be set")
+		}
 	}
 
 	return &Config{
 		SenderEmail:      senderEmail,
+		SenderName:       os.Getenv("SENDER_NAME"),
+		ReplyTo:          replyTo,
+		Bcc:              bcc,
 		Password:         password,
 		SMTPHost:         smtpHost,
 		SMTPPort:         smtpPort,
 		GOOGEL_SHEET_API: googelSheetApi,
+		JobStorePath:     jobStorePath,
+
+		SheetAPITimeout:         sheetAPITimeout,
+		SheetAPIConnectTimeout:  sheetAPIConnectTimeout,
+		SheetAPIMaxAttempts:     sheetAPIMaxAttempts,
+		SheetAPIRetryDelay:      sheetAPIRetryDelay,
+		SheetAPIToken:           os.Getenv("SHEET_API_TOKEN"),
+		SheetAPISecret:          os.Getenv("SHEET_API_SECRET"),
+		SheetAPISecretHeader:    sheetAPISecretHeader,
+		SheetAPIMaxResponseSize: int64(sheetAPIMaxResponseMB) << 20,
+
+		SMTPPoolSize:           smtpPoolSize,
+		SMTPIdleTimeout:        smtpIdleTimeout,
+		SMTPMaxMessagesPerConn: smtpMaxMessagesPerConn,
+		SMTPTimeout:            smtpTimeout,
+
+		SMTPTLSMode:       smtpTLSMode,
+		SMTPMinTLSVersion: smtpMinTLSVersion,
+		SMTPCACertFile:    os.Getenv("SMTP_CA_CERT_FILE"),
+		SMTPServerName:    os.Getenv("SMTP_SERVER_NAME"),
+
+		SMTPAuthMechanism:  smtpAuthMechanism,
+		SMTPUsername:       smtpUsername,
+		OAuth2ClientID:     os.Getenv("OAUTH2_CLIENT_ID"),
+		OAuth2ClientSecret: os.Getenv("OAUTH2_CLIENT_SECRET"),
+		OAuth2RefreshToken: oauth2RefreshToken,
+		OAuth2TokenURL:     oauth2TokenURL,
+
+		MaxSendAttempts: maxSendAttempts,
+		RetryBaseDelay:  retryBaseDelay,
+		RetryMaxDelay:   retryMaxDelay,
+
+		MaxConcurrentSends: maxConcurrentSends,
+
+		RateLimitPerMinute:     rateLimitPerMinute,
+		RateLimitPerHour:       rateLimitPerHour,
+		RateLimitPerDay:        rateLimitPerDay,
+		DomainRateLimitPerHour: domainRateLimitPerHour,
+
+		RecipientSource:    recipientSource,
+		RecipientFile:      recipientFile,
+		RecipientSQLDriver: recipientSQLDriver,
+		RecipientSQLDSN:    os.Getenv("RECIPIENT_SQL_DSN"),
+		RecipientSQLTable:  recipientSQLTable,
+
+		StatusOutboxPath:    statusOutboxPath,
+		StatusFlushInterval: statusFlushInterval,
+		StatusBatchSize:     statusBatchSize,
+
+		AttachmentSets:    attachmentSets,
+		MaxAttachmentSize: int64(maxAttachmentSizeMB) << 20,
+
+		TemplateDir:            templateDir,
+		TemplateReloadInterval: templateReloadInterval,
+		TemplateVars:           templateVars,
+		TemplateStrict:         templateStrict,
+		InlineCSS:              inlineCSS,
+		MinifyHTML:             minifyHTML,
 	}, nil
 }
 
@@ -50,3 +416,84 @@ func Load() (*Config, error) {
 func (c *Config) SMTPAddress() string {
 	return fmt.Sprintf("%s:%s", c.SMTPHost, c.SMTPPort)
 }
+
+// parseTLSVersion converts a version such as "1.2" to its crypto/tls constant,
+// defaulting to TLS 1.2
+func parseTLSVersion(value string) (uint16, error) {
+	switch value {
+	case "":
+		return tls.VersionTLS12, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+
+	return 0, fmt.Errorf("SMTP_MIN_TLS_VERSION must be one of 1.0, 1.1, 1.2 or 1.3, got %q", value)
+}
+
+// validateAddressList checks that an optional environment variable holds a
+// valid comma-separated address list
+func validateAddressList(key, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	if _, err := mail.ParseAddressList(value); err != nil {
+		return fmt.Errorf("%s must be a comma-separated list of email addresses: %w", key, err)
+	}
+	return nil
+}
+
+// parseAttachmentSets parses named attachment sets written as
+// "resume=files/resume.pdf;full=files/resume.pdf,files/portfolio.pdf".
+// Set names are case-insensitive.
+func parseAttachmentSets(value string) (map[string][]string, error) {
+	sets := make(map[string][]string)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, paths, ok := strings.Cut(entry, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("ATTACHMENT_SETS entries must look like name=path[,path...], got %q", entry)
+		}
+
+		for _, path := range strings.Split(paths, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				sets[name] = append(sets[name], path)
+			}
+		}
+		if len(sets[name]) == 0 {
+			return nil, fmt.Errorf("attachment set %q in ATTACHMENT_SETS has no files", name)
+		}
+	}
+
+	return sets, nil
+}
+
+// parseTemplateVars parses global template variables written as
+// "PortfolioURL=https://example.com;Phone=+91 98765 43210"
+func parseTemplateVars(value string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		name, varValue, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("TEMPLATE_VARS entries must look like Name=value, got %q", entry)
+		}
+		vars[name] = strings.TrimSpace(varValue)
+	}
+
+	return vars, nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// getEnvInt reads an integer environment variable, returning def when it is unset
+func getEnvInt(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer, got %q", key, value)
+	}
+
+	return n, nil
+}
+
+// getEnvDuration reads a duration environment variable (e.g. "90s", "5m"),
+// returning def when it is unset
+func getEnvDuration(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration such as 30s or 5m, got %q", key, value)
+	}
+
+	return d, nil
+}
+
+// getEnvBool reads a boolean environment variable ("true", "false", "1",
+// "0"), returning def when it is unset
+func getEnvBool(key string, def bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got %q", key, value)
+	}
+
+	return b, nil
+}
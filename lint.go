@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go_mailer/api"
+	"go_mailer/template"
+	"net/http"
+	"os"
+	"time"
+)
+
+// runLint implements "go_mailer lint": it checks every template in the
+// template directory for fields the data lacks, unbalanced HTML and broken
+// links, and returns the exit code
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	dir := flags.String("dir", "", "template directory (default TEMPLATE_DIR or tamplets)")
+	dataPath := flags.String("data", "", "JSON file with sample template data, layered over the sheet fields")
+	online := flags.Bool("online", false, "also request every web link and image")
+	flags.Parse(args)
+
+	cfg := loadCommandConfig()
+	if *dir != "" {
+		cfg.TemplateDir = *dir
+	}
+
+	data := api.SampleTemplateData(cfg)
+	if *dataPath != "" {
+		sample, err := readTemplateData(*dataPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 2
+		}
+		data = template.Merge(data, sample)
+	}
+
+	templates := template.NewRegistry(cfg.TemplateDir)
+	templates.SetStrict(cfg.TemplateStrict)
+	// Load reports the first broken template; lint each one instead
+	_ = templates.Load()
+
+	names := templates.Names()
+	if len(names) == 0 {
+		fmt.Fprintf(os.Stderr, "❌ No templates found in %s\n", cfg.TemplateDir)
+		return 1
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	errorCount, warningCount := 0, 0
+	for _, name := range names {
+		path, _ := templates.Path(name)
+		report := templates.Lint(path, data)
+		if *online {
+			for _, link := range report.Links {
+				if err := checkLink(client, link); err != nil {
+					report.Issues = append(report.Issues, template.LintIssue{
+						Severity: template.LintError,
+						Message:  fmt.Sprintf("broken link %s: %v", link, err),
+					})
+				}
+			}
+		}
+
+		if len(report.Issues) == 0 {
+			fmt.Printf("✅ %s (%s)\n", name, path)
+			continue
+		}
+		fmt.Printf("📄 %s (%s)\n", name, path)
+		for _, issue := range report.Issues {
+			if issue.Severity == template.LintError {
+				errorCount++
+				fmt.Printf("   ❌ %s\n", issue.Message)
+			} else {
+				warningCount++
+				fmt.Printf("   ⚠️ %s\n", issue.Message)
+			}
+		}
+	}
+
+	fmt.Printf("📊 %d templates, %d errors, %d warnings\n", len(names), errorCount, warningCount)
+	if errorCount > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkLink requests a web link, falling back to GET for servers that do
+// not answer HEAD
+func checkLink(client *http.Client, link string) error {
+	resp, err := client.Head(link)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		resp.Body.Close()
+		resp, err = client.Get(link)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
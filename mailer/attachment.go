@@ -0,0 +1,100 @@
+package mailer
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Attachment is a file sent along with an email. Files are read when the
+// email is sent, so a scheduled job only stores their paths.
+type Attachment struct {
+	Path        string // Location of the file on disk
+	Filename    string // Name shown to the recipient, defaults to the base name of Path
+	ContentType string // MIME type, detected from the file extension when empty
+	Inline      bool   // Display inside the message instead of as a download
+	ContentID   string // Referenced from the HTML body as "cid:<ContentID>"
+}
+
+// name returns the filename shown to the recipient
+func (a Attachment) name() string {
+	if a.Filename != "" {
+		return a.Filename
+	}
+	return filepath.Base(a.Path)
+}
+
+// mediaType returns the MIME type of the attachment
+func (a Attachment) mediaType() string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+	if detected := mime.TypeByExtension(strings.ToLower(filepath.Ext(a.name()))); detected != "" {
+		return detected
+	}
+	return "application/octet-stream"
+}
+
+// part reads the file and returns it as a base64 encoded MIME part
+func (a Attachment) part() (*mimePart, error) {
+	content, err := os.ReadFile(a.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading attachment: %w", err)
+	}
+
+	disposition := "attachment"
+	if a.Inline {
+		disposition = "inline"
+	}
+
+	// FormatMediaType applies RFC 2231 encoding to non-ASCII filenames
+	contentType := mime.FormatMediaType(a.mediaType(), map[string]string{"name": a.name()})
+	if contentType == "" {
+		contentType = a.mediaType()
+	}
+
+	headers := [][2]string{
+		{"Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.name()})},
+	}
+	if a.ContentID != "" {
+		headers = append(headers, [2]string{"Content-ID", "<" + a.ContentID + ">"})
+	}
+
+	return &mimePart{
+		contentType: contentType,
+		headers:     headers,
+		content:     content,
+		base64:      true,
+	}, nil
+}
+
+// ValidateAttachments checks that every attachment is a readable regular file
+// and that together they do not exceed maxTotalSize bytes (0 means no limit).
+// Base64 encoding grows attachments by a third on the wire, which providers
+// count against their message size limits.
+func ValidateAttachments(attachments []Attachment, maxTotalSize int64) error {
+	var total int64
+	for _, a := range attachments {
+		if a.Path == "" {
+			return fmt.Errorf("attachment %q has no path", a.Filename)
+		}
+
+		info, err := os.Stat(a.Path)
+		if err != nil {
+			return fmt.Errorf("error reading attachment: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("attachment %s is not a regular file", a.Path)
+		}
+
+		total += info.Size()
+	}
+
+	if maxTotalSize > 0 && total > maxTotalSize {
+		return fmt.Errorf("attachments total %d bytes, more than the limit of %d bytes", total, maxTotalSize)
+	}
+
+	return nil
+}
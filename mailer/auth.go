@@ -0,0 +1,190 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"go_mailer/config"
+	"go_mailer/logger"
+	"net/smtp"
+	"strings"
+)
+
+// loginAuth implements the AUTH LOGIN mechanism
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+// LoginAuth returns an smtp.Auth that implements the LOGIN mechanism. Like
+// smtp.PlainAuth it refuses to send credentials over an unencrypted
+// connection to anything but localhost.
+func LoginAuth(username, password, host string) smtp.Auth {
+	return &loginAuth{username: username, password: password, host: host}
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch prompt := strings.ToLower(strings.TrimSpace(string(fromServer))); {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN prompt %q", fromServer)
+	}
+}
+
+// xoauth2Auth implements the XOAUTH2 mechanism used by Gmail and Outlook
+type xoauth2Auth struct {
+	username string
+	tokens   TokenSource
+}
+
+// XOAuth2Auth returns an smtp.Auth that authenticates with an OAuth 2.0
+// access token obtained from tokens
+func XOAuth2Auth(username string, tokens TokenSource) smtp.Auth {
+	return &xoauth2Auth{username: username, tokens: tokens}
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+
+	token, err := a.tokens.Token()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// The server sent a JSON error challenge; an empty reply makes it
+		// finish the exchange with the actual error code
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+// isLocalhost reports whether the server name refers to the local machine
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// authenticator picks and runs the configured SMTP authentication mechanism
+type authenticator struct {
+	config *config.Config
+}
+
+// newAuthenticator creates an authenticator for the configured mechanism
+func newAuthenticator(cfg *config.Config) *authenticator {
+	return &authenticator{config: cfg}
+}
+
+// newTokenSource returns the OAuth token source for the configuration, or
+// nil when no refresh token is configured
+func newTokenSource(cfg *config.Config) TokenSource {
+	if cfg.OAuth2RefreshToken == "" {
+		return nil
+	}
+	return NewRefreshTokenSource(cfg.OAuth2ClientID, cfg.OAuth2ClientSecret, cfg.OAuth2RefreshToken, cfg.OAuth2TokenURL)
+}
+
+// selectMechanism chooses the mechanism to use from those the server
+// advertises. An explicitly configured mechanism must be advertised; in auto
+// mode the strongest available one is picked, XOAUTH2 only if there are
+// tokens to offer.
+func (a *authenticator) selectMechanism(advertised string, haveTokens bool) (string, error) {
+	offered := make(map[string]bool)
+	for _, mechanism := range strings.Fields(strings.ToLower(advertised)) {
+		offered[mechanism] = true
+	}
+
+	mechanism := a.config.SMTPAuthMechanism
+	if mechanism == "" || mechanism == config.AuthAuto {
+		preferred := []string{config.AuthPlain, config.AuthLogin, config.AuthCRAMMD5}
+		if haveTokens {
+			preferred = append([]string{config.AuthXOAuth2}, preferred...)
+		}
+		for _, candidate := range preferred {
+			if offered[candidate] {
+				return candidate, nil
+			}
+		}
+		return "", fmt.Errorf("server offers no supported AUTH mechanism (offered: %s)", advertised)
+	}
+
+	if !offered[mechanism] {
+		return "", fmt.Errorf("server does not offer AUTH %s (offered: %s)", strings.ToUpper(mechanism), advertised)
+	}
+	return mechanism, nil
+}
+
+// authenticate logs in on a freshly connected client, taking XOAUTH2 access
+// tokens from tokens
+func (a *authenticator) authenticate(client *smtp.Client, tokens TokenSource) error {
+	if a.config.SMTPAuthMechanism == config.AuthNone {
+		return nil
+	}
+
+	ok, advertised := client.Extension("AUTH")
+	if !ok {
+		if a.config.SMTPAuthMechanism == "" || a.config.SMTPAuthMechanism == config.AuthAuto {
+			// Nothing to negotiate, e.g. a local relay
+			return nil
+		}
+		return fmt.Errorf("server does not support AUTH but SMTP_AUTH_MECHANISM is %s", a.config.SMTPAuthMechanism)
+	}
+
+	mechanism, err := a.selectMechanism(advertised, tokens != nil)
+	if err != nil {
+		return err
+	}
+
+	username := a.config.SMTPUsername
+	if username == "" {
+		username = a.config.SenderEmail
+	}
+
+	var auth smtp.Auth
+	switch mechanism {
+	case config.AuthPlain:
+		auth = smtp.PlainAuth("", username, a.config.Password, a.config.SMTPHost)
+	case config.AuthLogin:
+		auth = LoginAuth(username, a.config.Password, a.config.SMTPHost)
+	case config.AuthCRAMMD5:
+		auth = smtp.CRAMMD5Auth(username, a.config.Password)
+	case config.AuthXOAuth2:
+		if tokens == nil {
+			return errors.New("XOAUTH2 requires an OAuth token source")
+		}
+		auth = XOAuth2Auth(username, tokens)
+	}
+
+	logger.Debug("🔐 Authenticating to %s with AUTH %s", a.config.SMTPAddress(), strings.ToUpper(mechanism))
+	if err := client.Auth(auth); err != nil {
+		if invalidator, ok := tokens.(tokenInvalidator); ok && mechanism == config.AuthXOAuth2 {
+			// The token may have been revoked early; fetch a new one next time
+			invalidator.Invalidate()
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package mailer
+
+import (
+	"fmt"
+	"go_mailer/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// startAuthServer starts a plain test server that offers the given AUTH
+// mechanisms and accepts ann's password and the access token "token-1"
+func startAuthServer(t *testing.T, mechanisms string) *testSMTPServer {
+	t.Helper()
+	server := startSMTPServer(t, newTestPKI(t), false, false, 0)
+	server.mu.Lock()
+	server.auth = mechanisms
+	server.username, server.password, server.token = "ann@example.com", "secret", "token-1"
+	server.mu.Unlock()
+	return server
+}
+
+// authConfig returns settings that log in to the server as ann
+func authConfig(server *testSMTPServer, mechanism string) *config.Config {
+	cfg := server.config(config.TLSModeNone)
+	cfg.SMTPAuthMechanism = mechanism
+	cfg.SMTPUsername = "ann@example.com"
+	cfg.Password = "secret"
+	return cfg
+}
+
+// authCommands returns the AUTH commands of a session
+func authCommands(server *testSMTPServer, session int) []string {
+	var commands []string
+	for _, command := range server.sessionCommands(session) {
+		if strings.HasPrefix(command, "AUTH") {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+func TestAuthMechanisms(t *testing.T) {
+	tests := []struct {
+		name      string
+		offered   string
+		mechanism string
+		tokens    TokenSource
+		password  string
+		want      string // The AUTH command sent; empty for none
+		wantErr   bool
+	}{
+		{name: "auto prefers PLAIN", offered: "LOGIN PLAIN CRAM-MD5", mechanism: config.AuthAuto, want: "AUTH PLAIN"},
+		{name: "auto falls back to LOGIN", offered: "LOGIN CRAM-MD5", mechanism: config.AuthAuto, want: "AUTH LOGIN"},
+		{name: "auto falls back to CRAM-MD5", offered: "CRAM-MD5", mechanism: config.AuthAuto, want: "AUTH CRAM-MD5"},
+		{name: "auto prefers XOAUTH2 with tokens", offered: "PLAIN XOAUTH2", mechanism: config.AuthAuto, tokens: StaticTokenSource("token-1"), want: "AUTH XOAUTH2"},
+		{name: "auto skips XOAUTH2 without tokens", offered: "PLAIN XOAUTH2", mechanism: config.AuthAuto, want: "AUTH PLAIN"},
+		{name: "auto without AUTH", offered: "", mechanism: config.AuthAuto},
+		{name: "auto with nothing supported", offered: "GSSAPI NTLM", mechanism: config.AuthAuto, wantErr: true},
+		{name: "explicit LOGIN", offered: "PLAIN LOGIN", mechanism: config.AuthLogin, want: "AUTH LOGIN"},
+		{name: "explicit CRAM-MD5", offered: "PLAIN CRAM-MD5", mechanism: config.AuthCRAMMD5, want: "AUTH CRAM-MD5"},
+		{name: "explicit mechanism not offered", offered: "PLAIN", mechanism: config.AuthCRAMMD5, wantErr: true},
+		{name: "explicit mechanism without AUTH", offered: "", mechanism: config.AuthPlain, wantErr: true},
+		{name: "explicit XOAUTH2 without tokens", offered: "XOAUTH2", mechanism: config.AuthXOAuth2, wantErr: true},
+		{name: "none", offered: "PLAIN", mechanism: config.AuthNone},
+		{name: "wrong PLAIN password", offered: "PLAIN", mechanism: config.AuthPlain, password: "wrong", want: "AUTH PLAIN", wantErr: true},
+		{name: "wrong LOGIN password", offered: "LOGIN", mechanism: config.AuthLogin, password: "wrong", want: "AUTH LOGIN", wantErr: true},
+		{name: "wrong CRAM-MD5 password", offered: "CRAM-MD5", mechanism: config.AuthCRAMMD5, password: "wrong", want: "AUTH CRAM-MD5", wantErr: true},
+		{name: "wrong XOAUTH2 token", offered: "XOAUTH2", mechanism: config.AuthXOAuth2, tokens: StaticTokenSource("expired"), want: "AUTH XOAUTH2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startAuthServer(t, tt.offered)
+			cfg := authConfig(server, tt.mechanism)
+			if tt.password != "" {
+				cfg.Password = tt.password
+			}
+			pool := newConnPool(cfg)
+			pool.setTokenSource(tt.tokens)
+			defer pool.close()
+
+			err := pool.send("ann@example.com", []string{"bob@example.com"}, testMessage)
+			if tt.wantErr != (err != nil) {
+				t.Errorf("send error = %v, want error %v", err, tt.wantErr)
+			}
+			got := strings.Join(authCommands(server, 1), ", ")
+			if got != tt.want {
+				t.Errorf("sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectMechanismIsCaseInsensitive(t *testing.T) {
+	a := newAuthenticator(&config.Config{SMTPAuthMechanism: config.AuthLogin})
+	if mechanism, err := a.selectMechanism("plain Login", false); err != nil || mechanism != config.AuthLogin {
+		t.Errorf("selectMechanism = %q, %v; want login", mechanism, err)
+	}
+}
+
+// tokenServer is an OAuth token endpoint that hands out numbered access tokens
+func tokenServer(t *testing.T, expiresIn int) (*httptest.Server, *int32) {
+	t.Helper()
+	var refreshes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("refresh_token") != "refresh" || r.PostForm.Get("grant_type") != "refresh_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"Bad Request"}`)
+			return
+		}
+		n := atomic.AddInt32(&refreshes, 1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":%d}`, n, expiresIn)
+	}))
+	t.Cleanup(server.Close)
+	return server, &refreshes
+}
+
+func TestRefreshTokenSource(t *testing.T) {
+	server, refreshes := tokenServer(t, 3600)
+	tokens := NewRefreshTokenSource("client", "secret", "refresh", server.URL)
+
+	for i := 0; i < 3; i++ {
+		if token, err := tokens.Token(); err != nil || token != "token-1" {
+			t.Fatalf("Token() = %q, %v; want the cached token-1", token, err)
+		}
+	}
+	tokens.Invalidate()
+	if token, err := tokens.Token(); err != nil || token != "token-2" {
+		t.Errorf("Token() after Invalidate = %q, %v; want token-2", token, err)
+	}
+	if n := atomic.LoadInt32(refreshes); n != 2 {
+		t.Errorf("%d refreshes, want 2", n)
+	}
+
+	// A token that expires within a minute is refreshed on every use
+	server, refreshes = tokenServer(t, 30)
+	tokens = NewRefreshTokenSource("client", "secret", "refresh", server.URL)
+	tokens.Token()
+	tokens.Token()
+	if n := atomic.LoadInt32(refreshes); n != 2 {
+		t.Errorf("%d refreshes of a short-lived token, want 2", n)
+	}
+
+	tokens = NewRefreshTokenSource("client", "secret", "revoked", server.URL)
+	if _, err := tokens.Token(); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("Token() with a revoked refresh token = %v, want invalid_grant", err)
+	}
+}
+
+func TestXOAuth2RefreshesRejectedToken(t *testing.T) {
+	tokenEndpoint, refreshes := tokenServer(t, 3600)
+	server := startAuthServer(t, "XOAUTH2")
+	cfg := authConfig(server, config.AuthXOAuth2)
+	cfg.OAuth2ClientID, cfg.OAuth2ClientSecret = "client", "secret"
+	cfg.OAuth2RefreshToken, cfg.OAuth2TokenURL = "refresh", tokenEndpoint.URL
+	cfg.SMTPPoolSize = 0
+	pool := newConnPool(cfg)
+	defer pool.close()
+
+	if err := pool.send("ann@example.com", []string{"bob@example.com"}, testMessage); err != nil {
+		t.Fatalf("send with token-1: %v", err)
+	}
+
+	// The server revokes token-1 before it expires: the send fails, the
+	// cached token is dropped and the next session fetches a new one
+	server.mu.Lock()
+	server.token = "token-2"
+	server.mu.Unlock()
+	if err := pool.send("ann@example.com", []string{"bob@example.com"}, testMessage); err == nil {
+		t.Fatal("send with a revoked token succeeded")
+	}
+	if err := pool.send("ann@example.com", []string{"bob@example.com"}, testMessage); err != nil {
+		t.Fatalf("send after refreshing: %v", err)
+	}
+	if n := atomic.LoadInt32(refreshes); n != 2 {
+		t.Errorf("%d refreshes, want 2", n)
+	}
+}
+
+func TestSetTokenSourceWhileSending(t *testing.T) {
+	server := startAuthServer(t, "XOAUTH2")
+	cfg := authConfig(server, config.AuthXOAuth2)
+	cfg.SMTPPoolSize = 0
+	m := New(cfg)
+	m.SetTokenSource(StaticTokenSource("token-1"))
+	defer m.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.pool.send("ann@example.com", []string{"bob@example.com"}, testMessage); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	m.SetTokenSource(StaticTokenSource("token-1"))
+	wg.Wait()
+}
@@ -5,59 +5,149 @@ import (
 	"go_mailer/config"
 	"go_mailer/logger"
 	"go_mailer/template"
+	"net/mail"
 	"net/smtp"
 	"os"
 )
 
-// Mailer handles sending emails using templates
+// Mailer handles sending emails using templates. SMTP sessions are pooled
+// and reused between sends.
 type Mailer struct {
 	config *config.Config
+	pool   *connPool
 }
 
 // New creates a new Mailer instance
 func New(cfg *config.Config) *Mailer {
 	return &Mailer{
 		config: cfg,
+		pool:   newConnPool(cfg),
 	}
 }
 
+// SetTokenSource replaces the OAuth 2.0 token source used for XOAUTH2
+// authentication. It is safe to call while emails are being sent; sessions
+// opened from then on use the new source.
+func (m *Mailer) SetTokenSource(tokens TokenSource) {
+	m.pool.setTokenSource(tokens)
+}
+
+// sender returns the From address, including the display name if configured
+func (m *Mailer) sender() string {
+	addr := mail.Address{Name: m.config.SenderName, Address: m.config.SenderEmail}
+	return addr.String()
+}
+
+// Close closes any idle SMTP connections held by the mailer
+func (m *Mailer) Close() {
+	m.pool.close()
+}
+
+// Email describes a templated email to send. Address fields hold
+// comma-separated lists such as "Ann <ann@example.com>, bob@example.com".
+type Email struct {
+	To           string
+	Cc           string
+	Bcc          string // Receives the email without appearing in its headers
+	ReplyTo      string
+	Subject      string // Rendered from the template's subject when empty
+	TemplatePath string
+	TemplateData template.TemplateData
+	Attachments  []Attachment
+}
+
 // SendWithTemplate sends an email with dynamically populated HTML template
 func (m *Mailer) SendWithTemplate(to string, subject string, htmlFilePath string, templateData template.TemplateData) error {
+	_, err := m.Send(&Email{
+		To:           to,
+		Subject:      subject,
+		TemplatePath: htmlFilePath,
+		TemplateData: templateData,
+	})
+	return err
+}
+
+// Send renders the email's template and sends it with its attachments. It
+// returns the Message-ID of the sent email.
+func (m *Mailer) Send(email *Email) (string, error) {
+	msg, err := m.Build(email)
+	if err != nil {
+		return "", err
+	}
+
+	message, err := msg.Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	err = m.pool.send(m.config.SenderEmail, msg.Recipients(), message)
+
+	if err != nil {
+		return "", fmt.Errorf("smtp error: %w", err)
+	}
+
+	logger.Info("Email sent successfully to %s", email.To)
+	return msg.MessageID, nil
+}
+
+// Build renders the email's template and assembles the message that Send
+// would deliver, without connecting to the SMTP server
+func (m *Mailer) Build(email *Email) (*Message, error) {
 	// Process the template with the provided data
-	processedHTML, err := template.Process(htmlFilePath, templateData)
+	processedHTML, images, err := template.Process(email.TemplatePath, email.TemplateData)
 	if err != nil {
-		return fmt.Errorf("template processing error: %w", err)
+		return nil, fmt.Errorf("template processing error: %w", err)
 	}
 
-	// Create proper email with MIME headers
-	header := make(map[string]string)
-	header["From"] = m.config.SenderEmail
-	header["To"] = to
-	header["Subject"] = subject
-	header["MIME-Version"] = "1.0"
-	header["Content-Type"] = "text/html; charset=\"utf-8\""
+	subject := email.Subject
+	if subject == "" {
+		var ok bool
+		subject, ok, err = template.ProcessSubject(email.TemplatePath, email.TemplateData)
+		if err != nil {
+			return nil, fmt.Errorf("subject template processing error: %w", err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("email has no subject and template %s defines none", email.TemplatePath)
+		}
+	}
 
-	// Construct message with proper headers
-	message := ""
-	for k, v := range header {
-		message += fmt.Sprintf("%s: %s\r\n", k, v)
+	// Build a standards-compliant message
+	msg, err := NewMessage(m.sender(), email.To, subject, processedHTML)
+	if err != nil {
+		return nil, err
+	}
+	// Apply the configured defaults
+	replyTo := email.ReplyTo
+	if replyTo == "" {
+		replyTo = m.config.ReplyTo
+	}
+	bcc := email.Bcc
+	if m.config.Bcc != "" {
+		if bcc != "" {
+			bcc += ", "
+		}
+		bcc += m.config.Bcc
 	}
-	message += "\r\n" + processedHTML
 
-	err = smtp.SendMail(
-		m.config.SMTPAddress(),
-		smtp.PlainAuth("", m.config.SenderEmail, m.config.Password, m.config.SMTPHost),
-		m.config.SenderEmail,
-		[]string{to},
-		[]byte(message),
-	)
+	if err := msg.SetAddresses(email.Cc, bcc, replyTo); err != nil {
+		return nil, err
+	}
+	msg.Attachments = email.Attachments
+	for _, image := range images {
+		msg.InlineImages = append(msg.InlineImages, Attachment{Path: image.Path, Inline: true, ContentID: image.ContentID})
+	}
 
+	// Prefer a hand-written .txt companion, otherwise derive the text part
+	processedText, ok, err := template.ProcessText(email.TemplatePath, email.TemplateData)
 	if err != nil {
-		return fmt.Errorf("smtp error: %w", err)
+		return nil, fmt.Errorf("text template processing error: %w", err)
+	}
+	if !ok {
+		processedText = template.HTMLToText(processedHTML)
 	}
+	msg.TextBody = processedText
 
-	logger.Info("Email sent successfully to %s", to)
-	return nil
+	return msg, nil
 }
 
 // Send is kept for backward compatibility
@@ -0,0 +1,264 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// maxHeaderLineLength is the length RFC 5322 recommends header lines stay under
+const maxHeaderLineLength = 78
+
+// The clock and random source behind the Date, Message-ID and multipart
+// boundaries of new messages. Tests replace them to get reproducible output.
+var (
+	now              = time.Now
+	random io.Reader = rand.Reader
+)
+
+// Message builds a standards-compliant RFC 5322 email. Headers are written in
+// a fixed order, non-ASCII text is RFC 2047 encoded and bodies are
+// quoted-printable so that no line exceeds the 998 character limit. When a
+// plain-text body is set the message is sent as multipart/alternative, inline
+// images are bundled with the HTML in multipart/related, and attachments wrap
+// the body in multipart/mixed. Bcc recipients receive the message but are
+// never written to its headers.
+type Message struct {
+	From         *mail.Address
+	To           []*mail.Address
+	Cc           []*mail.Address
+	Bcc          []*mail.Address
+	ReplyTo      []*mail.Address
+	Subject      string
+	Date         time.Time
+	MessageID    string
+	HTMLBody     string
+	TextBody     string
+	InlineImages []Attachment // Images referenced from HTMLBody by Content-ID
+	Attachments  []Attachment
+}
+
+// NewMessage creates a message from the sender and a comma-separated list of
+// recipients, generating its Date and Message-ID. Cc, Bcc and Reply-To can be
+// set with SetAddresses.
+func NewMessage(from, to, subject, htmlBody string) (*Message, error) {
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", from, err)
+	}
+
+	toAddrs, err := mail.ParseAddressList(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient address %q: %w", to, err)
+	}
+
+	return &Message{
+		From:      fromAddr,
+		To:        toAddrs,
+		Subject:   subject,
+		Date:      now(),
+		MessageID: generateMessageID(fromAddr.Address),
+		HTMLBody:  htmlBody,
+	}, nil
+}
+
+// generateMessageID returns a globally unique Message-ID in the sender's domain
+func generateMessageID(sender string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(sender, "@"); at >= 0 && at < len(sender)-1 {
+		domain = sender[at+1:]
+	}
+
+	unique := make([]byte, 12)
+	if _, err := io.ReadFull(random, unique); err != nil {
+		// Fall back to the clock, which is unique enough within one sender
+		return fmt.Sprintf("<%d@%s>", now().UnixNano(), domain)
+	}
+
+	return fmt.Sprintf("<%d.%s@%s>", now().UnixNano(), hex.EncodeToString(unique), domain)
+}
+
+// SetAddresses parses comma-separated Cc, Bcc and Reply-To lists; empty
+// lists are left unset
+func (m *Message) SetAddresses(cc, bcc, replyTo string) error {
+	var err error
+	if m.Cc, err = parseOptionalAddressList("cc", cc); err != nil {
+		return err
+	}
+	if m.Bcc, err = parseOptionalAddressList("bcc", bcc); err != nil {
+		return err
+	}
+	if m.ReplyTo, err = parseOptionalAddressList("reply-to", replyTo); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseOptionalAddressList parses an address list that may be empty
+func parseOptionalAddressList(field, list string) ([]*mail.Address, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	addrs, err := mail.ParseAddressList(list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s address %q: %w", field, list, err)
+	}
+	return addrs, nil
+}
+
+// Recipients returns the bare addresses the message must be delivered to:
+// every To, Cc and Bcc address, each listed once
+func (m *Message) Recipients() []string {
+	recipients := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	seen := make(map[string]bool)
+	for _, list := range [][]*mail.Address{m.To, m.Cc, m.Bcc} {
+		for _, addr := range list {
+			key := strings.ToLower(addr.Address)
+			if !seen[key] {
+				seen[key] = true
+				recipients = append(recipients, addr.Address)
+			}
+		}
+	}
+	return recipients
+}
+
+// Bytes renders the complete message with CRLF line endings
+func (m *Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	writeHeader(&buf, "Date", m.Date.Format(time.RFC1123Z))
+	writeHeader(&buf, "From", m.From.String())
+	writeHeader(&buf, "To", formatAddressList(m.To))
+	if len(m.Cc) > 0 {
+		writeHeader(&buf, "Cc", formatAddressList(m.Cc))
+	}
+	if len(m.ReplyTo) > 0 {
+		writeHeader(&buf, "Reply-To", formatAddressList(m.ReplyTo))
+	}
+	writeHeader(&buf, "Subject", encodeHeaderText(m.Subject))
+	writeHeader(&buf, "Message-ID", m.MessageID)
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	body, err := m.body()
+	if err != nil {
+		return nil, err
+	}
+	if err := body.write(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// body assembles the MIME structure of the message
+func (m *Message) body() (*mimePart, error) {
+	content, err := m.contentPart()
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Attachments) == 0 {
+		return content, nil
+	}
+
+	mixed := &mimePart{
+		multipart: "mixed",
+		children:  []*mimePart{content},
+	}
+	for _, attachment := range m.Attachments {
+		part, err := attachment.part()
+		if err != nil {
+			return nil, err
+		}
+		mixed.children = append(mixed.children, part)
+	}
+
+	return mixed, nil
+}
+
+// contentPart returns the readable content of the message: the HTML body
+// with its inline images, preceded by its plain-text alternative when there
+// is one
+func (m *Message) contentPart() (*mimePart, error) {
+	htmlPart := &mimePart{
+		contentType: `text/html; charset="utf-8"`,
+		content:     []byte(m.HTMLBody),
+	}
+
+	if len(m.InlineImages) > 0 {
+		related := &mimePart{
+			multipart: "related",
+			children:  []*mimePart{htmlPart},
+		}
+		for _, image := range m.InlineImages {
+			part, err := image.part()
+			if err != nil {
+				return nil, err
+			}
+			related.children = append(related.children, part)
+		}
+		htmlPart = related
+	}
+
+	if m.TextBody == "" {
+		return htmlPart, nil
+	}
+
+	// Clients show the last alternative they support, so HTML goes last
+	textPart := &mimePart{
+		contentType: `text/plain; charset="utf-8"`,
+		content:     []byte(m.TextBody),
+	}
+	return &mimePart{
+		multipart: "alternative",
+		children:  []*mimePart{textPart, htmlPart},
+	}, nil
+}
+
+// formatAddressList joins addresses into a header value, encoding display names
+func formatAddressList(addrs []*mail.Address) string {
+	formatted := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		formatted = append(formatted, addr.String())
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// encodeHeaderText RFC 2047 encodes text that is not plain printable ASCII
+func encodeHeaderText(text string) string {
+	for _, r := range text {
+		if r < 0x20 || r > 0x7e {
+			return mime.QEncoding.Encode("utf-8", text)
+		}
+	}
+	return text
+}
+
+// writeHeader writes a header field, folding it at spaces so that lines stay
+// under the recommended length. A first word too long to follow the name,
+// such as a 75 character RFC 2047 encoded word, starts on the next line.
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	line := name + ":"
+	lineLength := len(line)
+
+	for _, word := range strings.Split(value, " ") {
+		if line != "" && lineLength+1+len(word) > maxHeaderLineLength {
+			buf.WriteString(line)
+			buf.WriteString("\r\n")
+			line = ""
+			lineLength = 0
+		}
+		line += " " + word
+		lineLength += 1 + len(word)
+	}
+
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
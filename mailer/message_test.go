@@ -0,0 +1,215 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// counterReader is a reproducible random source yielding 0, 1, 2, ...
+type counterReader struct {
+	next byte
+}
+
+func (r *counterReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.next
+		r.next++
+	}
+	return len(p), nil
+}
+
+// fixClock makes the Date, Message-ID and boundaries of messages built by the
+// test reproducible
+func fixClock(t *testing.T) {
+	t.Helper()
+	savedNow, savedRandom := now, random
+	t.Cleanup(func() { now, random = savedNow, savedRandom })
+
+	date := time.Date(2024, time.March, 5, 9, 30, 0, 0, time.FixedZone("IST", 5*60*60+30*60))
+	now = func() time.Time { return date }
+	random = &counterReader{}
+}
+
+// newTestMessage builds a message with the clock fixed
+func newTestMessage(t *testing.T, from, to, subject, htmlBody string) *Message {
+	t.Helper()
+	msg, err := NewMessage(from, to, subject, htmlBody)
+	if err != nil {
+		t.Fatalf("NewMessage: %v", err)
+	}
+	return msg
+}
+
+// checkGolden compares the rendered message with testdata/<name>.eml, or
+// rewrites the file when run with -update
+func checkGolden(t *testing.T, name string, msg *Message) []byte {
+	t.Helper()
+	got, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+
+	path := filepath.Join("testdata", name+".eml")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("message differs from %s:\n--- got ---\n%s\n--- want ---\n%s", path, got, want)
+	}
+	return got
+}
+
+// headerNames returns the names of the top-level headers in order
+func headerNames(message []byte) []string {
+	head, _, _ := bytes.Cut(message, []byte("\r\n\r\n"))
+	var names []string
+	for _, line := range strings.Split(string(head), "\r\n") {
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestMessageHeaderOrder(t *testing.T) {
+	fixClock(t)
+	msg := newTestMessage(t, "Jane Doe <jane@example.com>", "ann@example.org, Bob <bob@example.net>",
+		"Regarding the Go Developer position", "<p>Hello</p>")
+	if err := msg.SetAddresses("carol@example.com", "hidden@example.com", "replies@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := checkGolden(t, "header_order", msg)
+
+	want := []string{"Date", "From", "To", "Cc", "Reply-To", "Subject", "Message-ID", "MIME-Version",
+		"Content-Type", "Content-Transfer-Encoding"}
+	if names := headerNames(got); strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("headers = %v, want %v", names, want)
+	}
+}
+
+func TestMessageRandomFailure(t *testing.T) {
+	fixClock(t)
+	random = iotest.ErrReader(errors.New("no entropy"))
+
+	// The Message-ID falls back to the clock
+	msg := newTestMessage(t, "jane@example.com", "ann@example.org", "Hello", "<p>Hello</p>")
+	if want := "<1709611200000000000@example.com>"; msg.MessageID != want {
+		t.Errorf("Message-ID = %s, want %s", msg.MessageID, want)
+	}
+
+	// A multipart message cannot get its boundary
+	msg.TextBody = "Hello"
+	if _, err := msg.Bytes(); err == nil || !strings.Contains(err.Error(), "no entropy") {
+		t.Errorf("Bytes() error = %v, want the random source failure", err)
+	}
+}
+
+func TestMessageStripsBcc(t *testing.T) {
+	fixClock(t)
+	msg := newTestMessage(t, "jane@example.com", "ann@example.org", "Hello", "<p>Hello</p>")
+	if err := msg.SetAddresses("", "hidden@example.com, Secret <secret@example.com>", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	got := checkGolden(t, "bcc_stripped", msg)
+
+	if bytes.Contains(got, []byte("Bcc")) || bytes.Contains(got, []byte("hidden@")) || bytes.Contains(got, []byte("secret@")) {
+		t.Errorf("Bcc recipients leaked into the message:\n%s", got)
+	}
+	recipients := strings.Join(msg.Recipients(), ",")
+	if want := "ann@example.org,hidden@example.com,secret@example.com"; recipients != want {
+		t.Errorf("Recipients() = %s, want %s", recipients, want)
+	}
+}
+
+func TestMessageEncodesHeaders(t *testing.T) {
+	fixClock(t)
+	msg := newTestMessage(t, "José Müller <jose@example.com>", "Zoë Ångström <zoe@example.org>",
+		"Bewerbung für die Stelle als Entwickler – Grüße aus München und eine recht lange Betreffzeile",
+		"<p>Hallo</p>")
+
+	got := checkGolden(t, "rfc2047", msg)
+
+	head, _, _ := bytes.Cut(got, []byte("\r\n\r\n"))
+	for _, line := range strings.Split(string(head), "\r\n") {
+		if len(line) > maxHeaderLineLength {
+			t.Errorf("header line of %d characters: %q", len(line), line)
+		}
+		for _, r := range line {
+			if r > 0x7e {
+				t.Errorf("unencoded non-ASCII header line: %q", line)
+				break
+			}
+		}
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dec mime.WordDecoder
+	if subject, _ := dec.DecodeHeader(parsed.Header.Get("Subject")); subject != msg.Subject {
+		t.Errorf("decoded subject = %q, want %q", subject, msg.Subject)
+	}
+	if from, err := parsed.Header.AddressList("From"); err != nil || from[0].Name != "José Müller" {
+		t.Errorf("decoded From = %v, %v", from, err)
+	}
+}
+
+func TestMessageQuotedPrintableLineLength(t *testing.T) {
+	fixClock(t)
+	long := strings.Repeat("Dies ist eine sehr lange Zeile ohne Umbruch, größer als erlaubt. ", 20)
+	msg := newTestMessage(t, "jane@example.com", "ann@example.org", "Long lines", "<p>"+long+"</p>\n<a href=\"https://example.com/"+strings.Repeat("a", 120)+"\">link</a>")
+	msg.TextBody = long + "\n\nUnix line endings are converted.\n"
+
+	got := checkGolden(t, "qp_line_length", msg)
+
+	for _, line := range strings.Split(string(got), "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("line of %d characters exceeds the quoted-printable limit: %q", len(line), line)
+		}
+	}
+	if bytes.Contains(bytes.ReplaceAll(got, []byte("\r\n"), nil), []byte("\n")) {
+		t.Error("message contains a bare LF")
+	}
+}
+
+func TestMessageAttachments(t *testing.T) {
+	fixClock(t)
+	dir := t.TempDir()
+	logo := filepath.Join(dir, "logo.png")
+	resume := filepath.Join(dir, "resume.pdf")
+	if err := os.WriteFile(logo, []byte("\x89PNG fake image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(resume, []byte("%PDF-1.4 fake document"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := newTestMessage(t, "jane@example.com", "ann@example.org", "With files", `<img src="cid:logo">`)
+	msg.TextBody = "See attached."
+	msg.InlineImages = []Attachment{{Path: logo, Inline: true, ContentID: "logo"}}
+	msg.Attachments = []Attachment{{Path: resume, Filename: "Lebenslauf Müller.pdf"}}
+
+	checkGolden(t, "attachments", msg)
+}
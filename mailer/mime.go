@@ -0,0 +1,107 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"strings"
+)
+
+// base64LineLength is the maximum line length of base64 encoded content
+const base64LineLength = 76
+
+// mimePart is a node of a MIME body: either a leaf with content or a
+// multipart container holding child parts
+type mimePart struct {
+	multipart   string // Subtype of a container, e.g. "alternative"; empty for leaves
+	children    []*mimePart
+	contentType string
+	headers     [][2]string // Additional part headers, in order
+	content     []byte
+	base64      bool // Encode content as base64 instead of quoted-printable
+}
+
+// write renders the part's headers, a blank line and its encoded body
+func (p *mimePart) write(buf *bytes.Buffer) error {
+	if p.multipart != "" {
+		boundary, err := newBoundary()
+		if err != nil {
+			return err
+		}
+		contentType := fmt.Sprintf(`multipart/%s; boundary="%s"`, p.multipart, boundary)
+		if p.multipart == "related" && len(p.children) > 0 {
+			// RFC 2387 requires the media type of the root part
+			rootType, _, _ := strings.Cut(p.children[0].contentType, ";")
+			contentType += fmt.Sprintf(`; type="%s"`, rootType)
+		}
+		writeHeader(buf, "Content-Type", contentType)
+		buf.WriteString("\r\n")
+
+		for _, child := range p.children {
+			buf.WriteString("--" + boundary + "\r\n")
+			if err := child.write(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("--" + boundary + "--\r\n")
+		return nil
+	}
+
+	writeHeader(buf, "Content-Type", p.contentType)
+	if p.base64 {
+		writeHeader(buf, "Content-Transfer-Encoding", "base64")
+	} else {
+		writeHeader(buf, "Content-Transfer-Encoding", "quoted-printable")
+	}
+	for _, header := range p.headers {
+		writeHeader(buf, header[0], header[1])
+	}
+	buf.WriteString("\r\n")
+
+	if p.base64 {
+		writeBase64(buf, p.content)
+		return nil
+	}
+	return writeQuotedPrintable(buf, string(p.content))
+}
+
+// newBoundary returns a random multipart boundary. Nested parts need distinct
+// boundaries, so a failed random read fails the message rather than falling
+// back to the clock.
+func newBoundary() (string, error) {
+	unique := make([]byte, 16)
+	if _, err := io.ReadFull(random, unique); err != nil {
+		return "", fmt.Errorf("error generating MIME boundary: %w", err)
+	}
+	return "=_" + hex.EncodeToString(unique), nil
+}
+
+// writeQuotedPrintable writes body quoted-printable encoded with CRLF line endings
+func writeQuotedPrintable(buf *bytes.Buffer, body string) error {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	body = strings.ReplaceAll(body, "\n", "\r\n")
+
+	w := quotedprintable.NewWriter(buf)
+	if _, err := w.Write([]byte(body)); err != nil {
+		return fmt.Errorf("error encoding message body: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error encoding message body: %w", err)
+	}
+
+	buf.WriteString("\r\n")
+	return nil
+}
+
+// writeBase64 writes content base64 encoded in lines of 76 characters
+func writeBase64(buf *bytes.Buffer, content []byte) {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > base64LineLength {
+		buf.WriteString(encoded[:base64LineLength] + "\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	buf.WriteString(encoded + "\r\n")
+}
@@ -0,0 +1,112 @@
+package mailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource supplies OAuth 2.0 access tokens for XOAUTH2 authentication
+type TokenSource interface {
+	// Token returns a valid access token, refreshing it if necessary
+	Token() (string, error)
+}
+
+// tokenInvalidator is implemented by token sources that cache tokens and can
+// be told that the cached token was rejected
+type tokenInvalidator interface {
+	Invalidate()
+}
+
+// StaticTokenSource always returns the same access token
+type StaticTokenSource string
+
+// Token returns the static token
+func (s StaticTokenSource) Token() (string, error) {
+	return string(s), nil
+}
+
+// RefreshTokenSource exchanges a long-lived refresh token for short-lived
+// access tokens at an OAuth 2.0 token endpoint and caches them until expiry
+type RefreshTokenSource struct {
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+	TokenURL     string
+	HTTPClient   *http.Client
+
+	accessToken string
+	expiry      time.Time
+	mu          sync.Mutex
+}
+
+// NewRefreshTokenSource creates a token source for the given OAuth client
+func NewRefreshTokenSource(clientID, clientSecret, refreshToken, tokenURL string) *RefreshTokenSource {
+	return &RefreshTokenSource{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RefreshToken: refreshToken,
+		TokenURL:     tokenURL,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// tokenResponse is the JSON returned by the token endpoint
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Token returns the cached access token, refreshing it shortly before it expires
+func (s *RefreshTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken != "" && time.Until(s.expiry) > time.Minute {
+		return s.accessToken, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", s.RefreshToken)
+	form.Set("client_id", s.ClientID)
+	form.Set("client_secret", s.ClientSecret)
+
+	resp, err := s.HTTPClient.Post(s.TokenURL, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("error refreshing OAuth token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("error reading OAuth token response: %w", err)
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("error parsing OAuth token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("OAuth token refresh failed (%s): %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+
+	s.accessToken = token.AccessToken
+	s.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return s.accessToken, nil
+}
+
+// Invalidate drops the cached access token so the next call refreshes it
+func (s *RefreshTokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accessToken = ""
+}
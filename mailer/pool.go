@@ -0,0 +1,265 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"go_mailer/config"
+	"go_mailer/logger"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// smtpConn is an authenticated SMTP session that can carry several messages
+type smtpConn struct {
+	client   *smtp.Client
+	conn     net.Conn
+	lastUsed time.Time
+	messages int
+}
+
+// close ends the session politely, falling back to dropping the connection
+func (c *smtpConn) close() {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.client.Quit(); err != nil {
+		c.client.Close()
+	}
+}
+
+// connPool keeps a small number of idle SMTP sessions for reuse between sends
+type connPool struct {
+	config    *config.Config
+	auth      *authenticator
+	tokens    TokenSource // For XOAUTH2; guarded by mu, since it can be replaced
+	idle      []*smtpConn
+	closed    bool
+	tlsOnce   sync.Once
+	tlsConfig *tls.Config
+	tlsErr    error
+	mu        sync.Mutex
+}
+
+// newConnPool creates an empty connection pool
+func newConnPool(cfg *config.Config) *connPool {
+	return &connPool{
+		config: cfg,
+		auth:   newAuthenticator(cfg),
+		tokens: newTokenSource(cfg),
+	}
+}
+
+// setTokenSource replaces the OAuth token source. Sessions that are already
+// open stay authenticated; new ones use the new source.
+func (p *connPool) setTokenSource(tokens TokenSource) {
+	p.mu.Lock()
+	p.tokens = tokens
+	p.mu.Unlock()
+}
+
+// timeout returns the deadline used for network operations
+func (p *connPool) timeout() time.Duration {
+	if p.config.SMTPTimeout > 0 {
+		return p.config.SMTPTimeout
+	}
+	return 30 * time.Second
+}
+
+// getTLSConfig builds the TLS settings on first use
+func (p *connPool) getTLSConfig() (*tls.Config, error) {
+	p.tlsOnce.Do(func() {
+		p.tlsConfig, p.tlsErr = buildTLSConfig(p.config)
+	})
+	return p.tlsConfig, p.tlsErr
+}
+
+// dial opens a new SMTP session, securing it according to the configured TLS
+// mode and authenticating with the configured mechanism
+func (p *connPool) dial() (*smtpConn, error) {
+	tlsConfig, err := p.getTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: p.timeout()}
+	var conn net.Conn
+	if p.config.SMTPTLSMode == config.TLSModeImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.config.SMTPAddress(), tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", p.config.SMTPAddress())
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(p.timeout()))
+
+	client, err := smtp.NewClient(conn, p.config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	switch p.config.SMTPTLSMode {
+	case "", config.TLSModeStartTLS, config.TLSModeRequiredStartTLS:
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, err
+			}
+		} else if p.config.SMTPTLSMode == config.TLSModeRequiredStartTLS {
+			client.Close()
+			return nil, fmt.Errorf("server %s does not offer STARTTLS, which SMTP_TLS_MODE requires", p.config.SMTPAddress())
+		} else {
+			logger.Warning("⚠️ Server %s does not offer STARTTLS, sending without encryption", p.config.SMTPAddress())
+		}
+	}
+
+	p.mu.Lock()
+	tokens := p.tokens
+	p.mu.Unlock()
+	if err := p.auth.authenticate(client, tokens); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	logger.Debug("🔌 Opened SMTP connection to %s", p.config.SMTPAddress())
+	return &smtpConn{client: client, conn: conn, lastUsed: time.Now()}, nil
+}
+
+// get returns a healthy idle session, or dials a new one. The second return
+// value reports whether the session was reused.
+func (p *connPool) get() (*smtpConn, bool, error) {
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if p.config.SMTPIdleTimeout > 0 && time.Since(c.lastUsed) > p.config.SMTPIdleTimeout {
+			c.close()
+			continue
+		}
+
+		// Make sure the server has not dropped the session while it was idle
+		c.conn.SetDeadline(time.Now().Add(p.timeout()))
+		if err := c.client.Noop(); err != nil {
+			logger.Debug("🔌 Discarding stale SMTP connection: %v", err)
+			c.client.Close()
+			continue
+		}
+
+		return c, true, nil
+	}
+
+	c, err := p.dial()
+	return c, false, err
+}
+
+// put returns a session to the pool after a message, or closes it if it is
+// broken, has carried its maximum number of messages or the pool is full
+func (p *connPool) put(c *smtpConn, broken bool) {
+	if broken {
+		c.client.Close()
+		return
+	}
+
+	if p.config.SMTPMaxMessagesPerConn > 0 && c.messages >= p.config.SMTPMaxMessagesPerConn {
+		c.close()
+		return
+	}
+
+	// Reset the transaction state so the next message starts clean
+	c.conn.SetDeadline(time.Now().Add(p.timeout()))
+	if err := c.client.Reset(); err != nil {
+		c.client.Close()
+		return
+	}
+	c.lastUsed = time.Now()
+
+	p.mu.Lock()
+	if p.closed || len(p.idle) >= p.config.SMTPPoolSize {
+		p.mu.Unlock()
+		c.close()
+		return
+	}
+	p.idle = append(p.idle, c)
+	p.mu.Unlock()
+}
+
+// send delivers one message over a pooled session. If a reused session turns
+// out to be dead, the message is retried once on a fresh connection.
+func (p *connPool) send(from string, to []string, message []byte) error {
+	c, reused, err := p.get()
+	if err != nil {
+		return err
+	}
+
+	dataSent, err := p.transmit(c, from, to, message)
+	if err != nil && reused && !dataSent && isConnectionError(err) {
+		// Only retry if the server cannot have received the message yet
+		logger.Debug("🔌 Reused SMTP connection failed, reconnecting: %v", err)
+		c.client.Close()
+		if c, err = p.dial(); err != nil {
+			return err
+		}
+		_, err = p.transmit(c, from, to, message)
+	}
+
+	p.put(c, err != nil && isConnectionError(err))
+	return err
+}
+
+// transmit runs a single MAIL/RCPT/DATA transaction. dataSent reports whether
+// the message body had started to go out when the transaction ended.
+func (p *connPool) transmit(c *smtpConn, from string, to []string, message []byte) (dataSent bool, err error) {
+	c.conn.SetDeadline(time.Now().Add(p.timeout()))
+	c.messages++
+
+	if err := c.client.Mail(from); err != nil {
+		return false, err
+	}
+	for _, addr := range to {
+		if err := c.client.Rcpt(addr); err != nil {
+			return false, err
+		}
+	}
+
+	w, err := c.client.Data()
+	if err != nil {
+		return false, err
+	}
+	if _, err := w.Write(message); err != nil {
+		return true, err
+	}
+	return true, w.Close()
+}
+
+// close shuts down every idle session and stops the pool from keeping new ones
+func (p *connPool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		c.close()
+	}
+}
+
+// isConnectionError reports whether err means the session itself is unusable,
+// as opposed to the server rejecting a single command
+func isConnectionError(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		// 421 means the server is closing the session
+		return protoErr.Code == 421
+	}
+	return true
+}
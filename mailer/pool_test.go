@@ -0,0 +1,716 @@
+package mailer
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"go_mailer/config"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPKI is a throwaway CA and a server certificate it signed for 127.0.0.1
+type testPKI struct {
+	caFile     string // PEM of the CA certificate
+	serverCert tls.Certificate
+}
+
+// newTestPKI creates a CA and a server certificate, writing the CA to a file
+// in the test's temporary directory
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go_mailer test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "mail.test"},
+		DNSNames:     []string{"mail.test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return &testPKI{
+		caFile:     caFile,
+		serverCert: tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey},
+	}
+}
+
+// testSMTPServer is a minimal SMTP server that speaks just enough of the
+// protocol to open sessions and accept messages: EHLO, STARTTLS, AUTH, MAIL,
+// RCPT, DATA, NOOP, RSET and QUIT
+type testSMTPServer struct {
+	listener net.Listener
+	tls      *tls.Config
+	starttls bool // Advertise STARTTLS on plain connections
+	wg       sync.WaitGroup
+
+	mu        sync.Mutex
+	dropAfter int    // Drop a session without a word after this many messages
+	shutAfter int    // Answer MAIL with 421 and end a session after this many messages
+	reject    string // Recipient answered with 550
+	auth      string // Advertised AUTH mechanisms, e.g. "PLAIN LOGIN"; none if empty
+	username  string // Credentials accepted by AUTH
+	password  string
+	token     string // Access token accepted by AUTH XOAUTH2
+	sessions  int
+	commands  []string // Every command received, prefixed with its session number
+	messages  int
+}
+
+// startSMTPServer listens on a local port. With implicit set every
+// connection is TLS from the first byte; otherwise STARTTLS is advertised if
+// starttls is set.
+func startSMTPServer(t *testing.T, pki *testPKI, implicit, starttls bool, maxVersion uint16) *testSMTPServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSMTPServer{
+		listener: listener,
+		tls: &tls.Config{
+			Certificates: []tls.Certificate{pki.serverCert},
+			MaxVersion:   maxVersion,
+		},
+		starttls: starttls,
+	}
+	if implicit {
+		s.listener = tls.NewListener(listener, s.tls)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+
+	t.Cleanup(func() {
+		s.listener.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+// config returns mailer settings pointing at the server
+func (s *testSMTPServer) config(mode string) *config.Config {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return &config.Config{
+		SMTPHost:    host,
+		SMTPPort:    port,
+		SMTPTLSMode: mode,
+		SMTPTimeout: 5 * time.Second,
+		SenderEmail: "sender@example.com",
+	}
+}
+
+// record logs a command of a session
+func (s *testSMTPServer) record(session int, command string) {
+	s.mu.Lock()
+	s.commands = append(s.commands, fmt.Sprintf("%d %s", session, command))
+	s.mu.Unlock()
+}
+
+// stats returns the number of sessions opened and messages accepted so far
+func (s *testSMTPServer) stats() (sessions, messages int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions, s.messages
+}
+
+// sessionCommands returns the commands of one session
+func (s *testSMTPServer) sessionCommands(session int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefix := fmt.Sprintf("%d ", session)
+	var commands []string
+	for _, command := range s.commands {
+		if strings.HasPrefix(command, prefix) {
+			commands = append(commands, strings.TrimPrefix(command, prefix))
+		}
+	}
+	return commands
+}
+
+// serve runs one SMTP session
+func (s *testSMTPServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	s.mu.Lock()
+	s.sessions++
+	session := s.sessions
+	dropAfter, shutAfter, reject := s.dropAfter, s.shutAfter, s.reject
+	mechanisms := s.auth
+	s.mu.Unlock()
+
+	_, secure := conn.(*tls.Conn)
+	reader := bufio.NewReader(conn)
+	reply := func(lines ...string) bool {
+		_, err := conn.Write([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+		return err == nil
+	}
+
+	if !reply("220 mail.test ESMTP ready") {
+		return
+	}
+	messages := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		verb = strings.ToUpper(verb)
+		if verb == "AUTH" {
+			mechanism, _, _ := strings.Cut(arg, " ")
+			s.record(session, verb+" "+strings.ToUpper(mechanism))
+		} else {
+			s.record(session, verb)
+		}
+
+		switch verb {
+		case "EHLO":
+			lines := []string{"250-mail.test"}
+			if s.starttls && !secure {
+				lines = append(lines, "250-STARTTLS")
+			}
+			if mechanisms != "" {
+				lines = append(lines, "250-AUTH "+mechanisms)
+			}
+			reply(append(lines, "250 8BITMIME")...)
+		case "STARTTLS":
+			if !s.starttls || secure {
+				reply("502 5.5.1 STARTTLS not available")
+				continue
+			}
+			reply("220 2.0.0 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, reader, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			if !strings.Contains(" "+mechanisms+" ", " "+strings.ToUpper(mechanism)+" ") {
+				reply("504 5.5.4 Unrecognized authentication type")
+				continue
+			}
+			ok, err := s.authenticate(strings.ToUpper(mechanism), initial, reader, reply)
+			if err != nil {
+				return
+			}
+			if ok {
+				reply("235 2.7.0 Accepted")
+			} else {
+				reply("535 5.7.8 Authentication credentials invalid")
+			}
+		case "MAIL":
+			if shutAfter > 0 && messages >= shutAfter {
+				reply("421 4.3.2 Service shutting down")
+				return
+			}
+			reply("250 2.1.0 OK")
+		case "RCPT":
+			if reject != "" && strings.Contains(arg, "<"+reject+">") {
+				reply("550 5.1.1 No such user")
+				continue
+			}
+			reply("250 2.1.5 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+			}
+			messages++
+			s.mu.Lock()
+			s.messages++
+			s.mu.Unlock()
+			reply("250 2.0.0 Queued")
+			if dropAfter > 0 && messages >= dropAfter {
+				return
+			}
+		case "NOOP", "RSET":
+			reply("250 2.0.0 OK")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// authenticate runs the exchange of an AUTH command and reports whether the
+// credentials are the accepted ones
+func (s *testSMTPServer) authenticate(mechanism, initial string, reader *bufio.Reader, reply func(...string) bool) (bool, error) {
+	// challenge sends a 334 challenge and returns the decoded answer
+	challenge := func(text string) (string, error) {
+		reply("334 " + base64.StdEncoding.EncodeToString([]byte(text)))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		answer, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+		return string(answer), err
+	}
+
+	s.mu.Lock()
+	username, password, token := s.username, s.password, s.token
+	s.mu.Unlock()
+
+	switch mechanism {
+	case "PLAIN":
+		response, err := base64.StdEncoding.DecodeString(initial)
+		if err != nil {
+			return false, err
+		}
+		return string(response) == "\x00"+username+"\x00"+password, nil
+	case "LOGIN":
+		user, err := challenge("Username:")
+		if err != nil {
+			return false, err
+		}
+		pass, err := challenge("Password:")
+		if err != nil {
+			return false, err
+		}
+		return user == username && pass == password, nil
+	case "CRAM-MD5":
+		nonce := "<1896.697170952@mail.test>"
+		answer, err := challenge(nonce)
+		if err != nil {
+			return false, err
+		}
+		mac := hmac.New(md5.New, []byte(password))
+		mac.Write([]byte(nonce))
+		return answer == username+" "+hex.EncodeToString(mac.Sum(nil)), nil
+	case "XOAUTH2":
+		response, err := base64.StdEncoding.DecodeString(initial)
+		if err != nil {
+			return false, err
+		}
+		if string(response) == "user="+username+"\x01auth=Bearer "+token+"\x01\x01" {
+			return true, nil
+		}
+		// Like Gmail, send the error as a challenge and fail on the answer
+		_, err = challenge(`{"status":"401","schemes":"bearer"}`)
+		return false, err
+	}
+	return false, nil
+}
+
+// dialTest opens a session with cfg and closes it when the test ends
+func dialTest(t *testing.T, cfg *config.Config) (*smtpConn, error) {
+	t.Helper()
+	c, err := newConnPool(cfg).dial()
+	if err == nil {
+		t.Cleanup(c.close)
+	}
+	return c, err
+}
+
+// tlsVersion returns the TLS version of the session, or 0 if it is plain
+func tlsVersion(c *smtpConn) uint16 {
+	if state, ok := c.client.TLSConnectionState(); ok {
+		return state.Version
+	}
+	if tlsConn, ok := c.conn.(*tls.Conn); ok {
+		return tlsConn.ConnectionState().Version
+	}
+	return 0
+}
+
+func TestDialImplicitTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	server := startSMTPServer(t, pki, true, false, 0)
+	cfg := server.config(config.TLSModeImplicit)
+	cfg.SMTPCACertFile = pki.caFile
+
+	c, err := dialTest(t, cfg)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if tlsVersion(c) == 0 {
+		t.Error("implicit TLS session is not encrypted")
+	}
+	if err := c.client.Noop(); err != nil {
+		t.Errorf("NOOP over implicit TLS: %v", err)
+	}
+}
+
+func TestDialStartTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	server := startSMTPServer(t, pki, false, true, 0)
+	cfg := server.config(config.TLSModeRequiredStartTLS)
+	cfg.SMTPCACertFile = pki.caFile
+
+	c, err := dialTest(t, cfg)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if tlsVersion(c) == 0 {
+		t.Error("session was not upgraded with STARTTLS")
+	}
+}
+
+func TestDialRequiredStartTLSWithoutSupport(t *testing.T) {
+	pki := newTestPKI(t)
+	server := startSMTPServer(t, pki, false, false, 0)
+	cfg := server.config(config.TLSModeRequiredStartTLS)
+	cfg.SMTPCACertFile = pki.caFile
+
+	_, err := dialTest(t, cfg)
+	if err == nil || !strings.Contains(err.Error(), "does not offer STARTTLS") {
+		t.Fatalf("dial error = %v, want a missing STARTTLS error", err)
+	}
+}
+
+func TestDialOpportunisticStartTLSWithoutSupport(t *testing.T) {
+	pki := newTestPKI(t)
+	server := startSMTPServer(t, pki, false, false, 0)
+	cfg := server.config(config.TLSModeStartTLS)
+
+	c, err := dialTest(t, cfg)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if tlsVersion(c) != 0 {
+		t.Error("session is encrypted although the server offers no STARTTLS")
+	}
+}
+
+func TestDialCustomCA(t *testing.T) {
+	pki := newTestPKI(t)
+
+	t.Run("trusted", func(t *testing.T) {
+		server := startSMTPServer(t, pki, true, false, 0)
+		cfg := server.config(config.TLSModeImplicit)
+		cfg.SMTPCACertFile = pki.caFile
+		if _, err := dialTest(t, cfg); err != nil {
+			t.Fatalf("dial with the server's CA: %v", err)
+		}
+	})
+
+	t.Run("system pool", func(t *testing.T) {
+		server := startSMTPServer(t, pki, true, false, 0)
+		cfg := server.config(config.TLSModeImplicit)
+		if _, err := dialTest(t, cfg); err == nil {
+			t.Fatal("dial trusted a certificate from an unknown CA")
+		}
+	})
+
+	t.Run("other CA", func(t *testing.T) {
+		server := startSMTPServer(t, pki, false, true, 0)
+		cfg := server.config(config.TLSModeRequiredStartTLS)
+		cfg.SMTPCACertFile = newTestPKI(t).caFile
+		if _, err := dialTest(t, cfg); err == nil {
+			t.Fatal("STARTTLS trusted a certificate from another CA")
+		}
+	})
+
+	t.Run("server name", func(t *testing.T) {
+		server := startSMTPServer(t, pki, true, false, 0)
+		cfg := server.config(config.TLSModeImplicit)
+		cfg.SMTPCACertFile = pki.caFile
+		cfg.SMTPServerName = "mail.test"
+		if _, err := dialTest(t, cfg); err != nil {
+			t.Fatalf("dial verifying mail.test: %v", err)
+		}
+
+		cfg = server.config(config.TLSModeImplicit)
+		cfg.SMTPCACertFile = pki.caFile
+		cfg.SMTPServerName = "other.test"
+		if _, err := dialTest(t, cfg); err == nil {
+			t.Fatal("dial accepted a certificate for another name")
+		}
+	})
+}
+
+func TestDialMinTLSVersion(t *testing.T) {
+	pki := newTestPKI(t)
+
+	tests := []struct {
+		name      string
+		mode      string
+		serverMax uint16
+		clientMin uint16
+		wantErr   bool
+		wantLeast uint16
+	}{
+		{"default accepts TLS 1.2", config.TLSModeImplicit, tls.VersionTLS12, 0, false, tls.VersionTLS12},
+		{"default refuses TLS 1.1", config.TLSModeImplicit, tls.VersionTLS11, 0, true, 0},
+		{"1.3 refuses TLS 1.2", config.TLSModeImplicit, tls.VersionTLS12, tls.VersionTLS13, true, 0},
+		{"1.3 accepts TLS 1.3", config.TLSModeImplicit, 0, tls.VersionTLS13, false, tls.VersionTLS13},
+		{"1.3 refuses TLS 1.2 over STARTTLS", config.TLSModeStartTLS, tls.VersionTLS12, tls.VersionTLS13, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			implicit := tt.mode == config.TLSModeImplicit
+			server := startSMTPServer(t, pki, implicit, !implicit, tt.serverMax)
+			cfg := server.config(tt.mode)
+			cfg.SMTPCACertFile = pki.caFile
+			cfg.SMTPMinTLSVersion = tt.clientMin
+
+			c, err := dialTest(t, cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("dial succeeded with TLS version %#x", tlsVersion(c))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			if v := tlsVersion(c); v < tt.wantLeast {
+				t.Errorf("negotiated TLS version %#x, want at least %#x", v, tt.wantLeast)
+			}
+		})
+	}
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	tlsConfig, err := buildTLSConfig(&config.Config{SMTPHost: "smtp.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("default MinVersion = %#x, want TLS 1.2", tlsConfig.MinVersion)
+	}
+	if tlsConfig.ServerName != "smtp.example.com" {
+		t.Errorf("ServerName = %q, want the SMTP host", tlsConfig.ServerName)
+	}
+	if tlsConfig.RootCAs != nil {
+		t.Error("RootCAs set without a CA bundle")
+	}
+
+	if _, err := buildTLSConfig(&config.Config{SMTPCACertFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("missing CA bundle accepted")
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buildTLSConfig(&config.Config{SMTPCACertFile: empty}); err == nil {
+		t.Error("CA bundle without certificates accepted")
+	}
+}
+
+// testMessage is the message the pool tests send
+var testMessage = []byte("Subject: Test\r\n\r\nHello\r\n")
+
+// poolTest returns a pool for plain sessions with the server, keeping one idle
+// session unless the test changes the settings
+func poolTest(t *testing.T, server *testSMTPServer, configure func(cfg *config.Config)) *connPool {
+	t.Helper()
+	cfg := server.config(config.TLSModeNone)
+	cfg.SMTPPoolSize = 1
+	if configure != nil {
+		configure(cfg)
+	}
+	pool := newConnPool(cfg)
+	t.Cleanup(pool.close)
+	return pool
+}
+
+// sendMessages sends n messages through the pool
+func sendMessages(t *testing.T, pool *connPool, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := pool.send("sender@example.com", []string{"ann@example.com"}, testMessage); err != nil {
+			t.Fatalf("message %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestPoolReusesSession(t *testing.T) {
+	server := startSMTPServer(t, newTestPKI(t), false, false, 0)
+	pool := poolTest(t, server, nil)
+
+	sendMessages(t, pool, 3)
+	pool.close()
+
+	if sessions, messages := server.stats(); sessions != 1 || messages != 3 {
+		t.Fatalf("%d sessions for %d messages, want one session", sessions, messages)
+	}
+	// Every message but the first checks the idle session with NOOP, and
+	// every one is followed by RSET
+	got := strings.Join(server.sessionCommands(1), " ")
+	want := "EHLO MAIL RCPT DATA RSET NOOP MAIL RCPT DATA RSET NOOP MAIL RCPT DATA RSET QUIT"
+	if got != want {
+		t.Errorf("commands %s, want %s", got, want)
+	}
+}
+
+func TestPoolMaxMessagesPerConn(t *testing.T) {
+	server := startSMTPServer(t, newTestPKI(t), false, false, 0)
+	pool := poolTest(t, server, func(cfg *config.Config) { cfg.SMTPMaxMessagesPerConn = 2 })
+
+	sendMessages(t, pool, 5)
+
+	if sessions, _ := server.stats(); sessions != 3 {
+		t.Errorf("%d sessions for 5 messages at 2 per session, want 3", sessions)
+	}
+	for session := 1; session <= 2; session++ {
+		commands := server.sessionCommands(session)
+		if last := commands[len(commands)-1]; last != "QUIT" {
+			t.Errorf("full session %d ended with %s, want QUIT", session, last)
+		}
+	}
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	server := startSMTPServer(t, newTestPKI(t), false, false, 0)
+	pool := poolTest(t, server, func(cfg *config.Config) { cfg.SMTPIdleTimeout = 50 * time.Millisecond })
+
+	sendMessages(t, pool, 2)
+	time.Sleep(100 * time.Millisecond)
+	sendMessages(t, pool, 1)
+
+	if sessions, _ := server.stats(); sessions != 2 {
+		t.Fatalf("%d sessions, want a new one after the idle timeout", sessions)
+	}
+	commands := server.sessionCommands(1)
+	if last := commands[len(commands)-1]; last != "QUIT" {
+		t.Errorf("expired session ended with %s, want QUIT", last)
+	}
+}
+
+func TestPoolSizeLimit(t *testing.T) {
+	server := startSMTPServer(t, newTestPKI(t), false, false, 0)
+	pool := poolTest(t, server, func(cfg *config.Config) { cfg.SMTPPoolSize = 0 })
+
+	sendMessages(t, pool, 2)
+
+	if sessions, _ := server.stats(); sessions != 2 {
+		t.Errorf("%d sessions, want one per message without idle sessions", sessions)
+	}
+}
+
+func TestPoolDiscardsDroppedSession(t *testing.T) {
+	server := startSMTPServer(t, newTestPKI(t), false, false, 0)
+	server.mu.Lock()
+	server.dropAfter = 1
+	server.mu.Unlock()
+	pool := poolTest(t, server, nil)
+
+	sendMessages(t, pool, 2)
+
+	if sessions, messages := server.stats(); sessions != 2 || messages != 2 {
+		t.Errorf("%d sessions for %d messages, want a new session after the drop", sessions, messages)
+	}
+}
+
+func TestPoolReconnectsWhenReusedSessionCloses(t *testing.T) {
+	server := startSMTPServer(t, newTestPKI(t), false, false, 0)
+	server.mu.Lock()
+	server.shutAfter = 1
+	server.mu.Unlock()
+	pool := poolTest(t, server, nil)
+
+	// The idle session passes NOOP but is closed at MAIL, before the
+	// message went out, so it is sent again on a new session
+	sendMessages(t, pool, 2)
+
+	if sessions, messages := server.stats(); sessions != 2 || messages != 2 {
+		t.Errorf("%d sessions for %d messages, want a retry on a new session", sessions, messages)
+	}
+	got := strings.Join(server.sessionCommands(1), " ")
+	if want := "EHLO MAIL RCPT DATA RSET NOOP MAIL"; got != want {
+		t.Errorf("first session commands %s, want %s", got, want)
+	}
+}
+
+func TestPoolKeepsSessionAfterRejection(t *testing.T) {
+	server := startSMTPServer(t, newTestPKI(t), false, false, 0)
+	server.mu.Lock()
+	server.reject = "nobody@example.com"
+	server.mu.Unlock()
+	pool := poolTest(t, server, nil)
+
+	// A rejected recipient is the server's answer to one message, not a
+	// broken session, so the session is reset and reused
+	if err := pool.send("sender@example.com", []string{"nobody@example.com"}, testMessage); err == nil {
+		t.Fatal("rejected recipient accepted")
+	}
+	sendMessages(t, pool, 1)
+
+	if sessions, messages := server.stats(); sessions != 1 || messages != 1 {
+		t.Errorf("%d sessions for %d messages, want the session kept", sessions, messages)
+	}
+	got := strings.Join(server.sessionCommands(1), " ")
+	if want := "EHLO MAIL RCPT RSET NOOP MAIL RCPT DATA RSET"; got != want {
+		t.Errorf("commands %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"go_mailer/config"
+	"os"
+)
+
+// buildTLSConfig creates the TLS settings used for SMTP connections from the
+// configured minimum version, CA bundle and server name override
+func buildTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	serverName := cfg.SMTPServerName
+	if serverName == "" {
+		serverName = cfg.SMTPHost
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+		MinVersion: cfg.SMTPMinTLSVersion,
+	}
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+
+	if cfg.SMTPCACertFile != "" {
+		pem, err := os.ReadFile(cfg.SMTPCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SMTP CA bundle: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in SMTP CA bundle %s", cfg.SMTPCACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
@@ -6,6 +6,7 @@ import (
 	"go_mailer/logger"
 	"go_mailer/scheduler"
 	"go_mailer/template"
+	"io"
 	"os"
 	"os/signal"
 	"syscall"
@@ -18,6 +19,14 @@ import (
 // the <icon src="AllIcons.Actions.Execute"/> icon in the gutter and select the <b>Run</b> menu item from here.</p>
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			loadEnvFile()
+			os.Exit(run(os.Args[2:]))
+		}
+	}
+
 	// Set up initial log message with timestamp
 	logger.Info("🚀 Starting Go Mailer Service - %s", time.Now().Format("2006-01-02 15:04:05"))
 
@@ -32,20 +41,42 @@ func main() {
 	}
 	logger.Info("✅ Configuration loaded successfully")
 
+	// Discover and parse the email templates
+	templates := template.NewRegistry(cfg.TemplateDir)
+	templates.SetStrict(cfg.TemplateStrict)
+	templates.SetInlineCSS(cfg.InlineCSS)
+	templates.SetMinify(cfg.MinifyHTML)
+	if err := templates.Load(); err != nil {
+		logger.Fatal("❌ Failed to load email templates: %v", err)
+	}
+	template.SetDefaultRegistry(templates)
+	templates.Watch(cfg.TemplateReloadInterval)
+
 	// Create a scheduler instance
-	emailScheduler := scheduler.New(cfg)
+	emailScheduler, err := scheduler.New(cfg)
+	if err != nil {
+		logger.Fatal("❌ Failed to create email scheduler: %v", err)
+	}
+
+	// Open the configured recipient source
+	source, err := api.NewRecipientSource(cfg)
+	if err != nil {
+		logger.Fatal("❌ Failed to open recipient source: %v", err)
+	}
+	// Write every job's status back to its row
+	emailScheduler.SetStatusHook(api.SourceStatusHook(source))
 
 	// Start the scheduler
 	emailScheduler.Start()
 
 	// Set up graceful shutdown
-	setupGracefulShutdown(emailScheduler)
+	setupGracefulShutdown(emailScheduler, source)
 
-	// Schedule emails from Google Sheet immediately
-	logger.Info("🔄 Initiating first Google Sheet check...")
-	err = api.ScheduleEmailsFromGoogleSheet(emailScheduler, cfg)
+	// Schedule emails from the recipient source immediately
+	logger.Info("🔄 Initiating first check of %s...", source.Name())
+	err = api.ScheduleEmailsFromSource(emailScheduler, source, cfg)
 	if err != nil {
-		logger.Error("❌ Error during initial scheduling from Google Sheet: %v", err)
+		logger.Error("❌ Error during initial scheduling from %s: %v", source.Name(), err)
 	}
 
 	// Set up a ticker to check for new entries every 2 hours
@@ -55,25 +86,27 @@ func main() {
 	ticker := time.NewTicker(checkInterval)
 	go func() {
 		for t := range ticker.C {
-			logger.Info("🔄 Scheduled check at %s - Checking Google Sheet for new emails...",
-				t.Format("2006-01-02 15:04:05"))
-			err := api.ScheduleEmailsFromGoogleSheet(emailScheduler, cfg)
+			logger.Info("🔄 Scheduled check at %s - Checking %s for new emails...",
+				t.Format("2006-01-02 15:04:05"), source.Name())
+			err := api.ScheduleEmailsFromSource(emailScheduler, source, cfg)
 			if err != nil {
-				logger.Error("❌ Error scheduling emails from Google Sheet: %v", err)
+				logger.Error("❌ Error scheduling emails from %s: %v", source.Name(), err)
 			}
+			logger.Info("🚦 Send budget usage: %s", emailScheduler.RateLimitUsage())
 		}
 	}()
 
 	// Wait for scheduler to run
 	logger.Info("✅ Application running. Press Ctrl+C to exit.")
-	logger.Info("🔍 Available templates:")
-	logger.Info("   - Default: %s", template.DefaultEmailTemplate)
-	logger.Info("   - Casual: %s", template.CasualEmailTemplate)
-	logger.Info("   - Minimal: %s", template.MinimalEmailTemplate)
+	logger.Info("🔍 Available templates in %s:", templates.Dir())
+	for _, name := range templates.Names() {
+		path, _ := templates.Path(name)
+		logger.Info("   - %s: %s", name, path)
+	}
 	select {}
 }
 
-func setupGracefulShutdown(emailScheduler *scheduler.Scheduler) {
+func setupGracefulShutdown(emailScheduler *scheduler.Scheduler, source api.RecipientSource) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
@@ -81,6 +114,13 @@ func setupGracefulShutdown(emailScheduler *scheduler.Scheduler) {
 		<-c
 		logger.Info("🛑 Shutdown signal received")
 		emailScheduler.Stop()
+		// Sources that queue status updates send them now
+		if closer, ok := source.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logger.Error("❌ Error closing %s: %v", source.Name(), err)
+			}
+		}
+		template.DefaultRegistry().StopWatching()
 		logger.Info("👋 Application shutdown complete")
 		os.Exit(0)
 	}()
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"go_mailer/api"
+	"go_mailer/config"
+	"go_mailer/mailer"
+	"go_mailer/template"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fieldFlags collects repeated -set Key=value flags
+type fieldFlags map[string]string
+
+func (f fieldFlags) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f fieldFlags) Set(value string) error {
+	key, val, found := strings.Cut(value, "=")
+	if !found || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("expected Key=value, got %q", value)
+	}
+	f[strings.TrimSpace(key)] = val
+	return nil
+}
+
+// previewer renders an email the way the scheduler would send it
+type previewer struct {
+	cfg          *config.Config
+	mailer       *mailer.Mailer
+	templateName string
+	dataPath     string
+	fields       fieldFlags
+	row          *mailer.Email // The recipient row, fetched once; nil without -row
+	to           string
+}
+
+// rendering is the result of rendering the email once
+type rendering struct {
+	msg     *mailer.Message
+	eml     []byte
+	html    string // HTMLBody with cid: references rewritten for viewing
+	version string // Changes whenever the rendered content changes
+	err     error
+}
+
+// email returns the email to render, re-reading the data file so that
+// edits to it show up
+func (p *previewer) email() (*mailer.Email, error) {
+	email := &mailer.Email{To: p.to, TemplateData: api.SampleTemplateData(p.cfg)}
+	if p.row != nil {
+		row := *p.row
+		email = &row
+	}
+
+	if p.row == nil || p.templateName != "" {
+		path, err := template.DefaultRegistry().Path(p.templateName)
+		if err != nil {
+			return nil, err
+		}
+		email.TemplatePath = path
+	}
+
+	layers := []template.TemplateData{email.TemplateData}
+	if p.dataPath != "" {
+		data, err := readTemplateData(p.dataPath)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, data)
+	}
+	fields := make(template.TemplateData, len(p.fields))
+	for key, value := range p.fields {
+		fields[key] = value
+	}
+	email.TemplateData = template.Merge(append(layers, fields)...)
+
+	return email, nil
+}
+
+// render builds the complete message. imageURL maps each inline image to the
+// URL it is shown from in the .html preview.
+func (p *previewer) render(imageURL func(index int, image mailer.Attachment) string) *rendering {
+	result := &rendering{}
+
+	email, err := p.email()
+	if err == nil {
+		result.msg, err = p.mailer.Build(email)
+	}
+	if err == nil {
+		result.eml, err = result.msg.Bytes()
+	}
+	if err != nil {
+		result.err = err
+		result.version = hashStrings(err.Error())
+		return result
+	}
+
+	result.html = result.msg.HTMLBody
+	for i, image := range result.msg.InlineImages {
+		result.html = strings.ReplaceAll(result.html, "cid:"+image.ContentID, imageURL(i, image))
+	}
+	// Content-IDs are random, so the version is taken after replacing them
+	result.version = hashStrings(result.msg.Subject, result.msg.TextBody, result.html)
+
+	return result
+}
+
+// hashStrings returns a short hash of the given strings
+func hashStrings(values ...string) string {
+	h := sha256.New()
+	for _, value := range values {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// runPreview implements "go_mailer preview": it renders an email without
+// sending it and either writes it to disk or serves it with live reload
+func runPreview(args []string) int {
+	flags := flag.NewFlagSet("preview", flag.ExitOnError)
+	templateName := flags.String("template", "", "template name (default: the row's template, or email)")
+	dataPath := flags.String("data", "", "JSON file with template data, layered over the sheet fields")
+	row := flags.Int("row", 0, "render this unsent row of the recipient source, counting from 1")
+	to := flags.String("to", "recipient@example.com", "recipient address when not rendering a row")
+	out := flags.String("out", "preview", "directory the .eml and .html files are written to")
+	serve := flags.String("serve", "", "serve the preview with live reload on this address, e.g. localhost:8025")
+	fields := make(fieldFlags)
+	flags.Var(fields, "set", "template field as Key=value; may be repeated")
+	flags.Parse(args)
+
+	cfg := loadCommandConfig()
+	if cfg.SenderEmail == "" {
+		cfg.SenderEmail = "sender@example.com"
+	}
+
+	templates := template.NewRegistry(cfg.TemplateDir)
+	templates.SetStrict(cfg.TemplateStrict)
+	templates.SetInlineCSS(cfg.InlineCSS)
+	templates.SetMinify(cfg.MinifyHTML)
+	if err := templates.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ %v\n", err)
+	}
+	template.SetDefaultRegistry(templates)
+
+	p := &previewer{
+		cfg:          cfg,
+		mailer:       mailer.New(cfg),
+		templateName: *templateName,
+		dataPath:     *dataPath,
+		fields:       fields,
+		to:           *to,
+	}
+	name := *templateName
+	if *row > 0 {
+		source, err := api.NewReadOnlyRecipientSource(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 1
+		}
+		job, err := api.RowJob(source, cfg, *row)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 1
+		}
+		p.row = job.Email()
+		name = fmt.Sprintf("row-%d", *row)
+	}
+	if name == "" {
+		name = template.DefaultTemplateName
+	}
+
+	if *serve != "" {
+		templates.Watch(500 * time.Millisecond)
+		return servePreview(p, *serve)
+	}
+	return writePreview(p, *out, name)
+}
+
+// writePreview writes the rendered email as name.eml and name.html to dir.
+// Inline images are linked from the .html file by their path.
+func writePreview(p *previewer, dir, name string) int {
+	result := p.render(func(_ int, image mailer.Attachment) string {
+		path, err := filepath.Abs(image.Path)
+		if err != nil {
+			path = image.Path
+		}
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	})
+	if result.err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", result.err)
+		return 1
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	emlPath := filepath.Join(dir, name+".eml")
+	htmlPath := filepath.Join(dir, name+".html")
+	if err := os.WriteFile(emlPath, result.eml, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(htmlPath, []byte(result.html), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("📧 Subject: %s\n", result.msg.Subject)
+	fmt.Printf("✅ Wrote %s (%d bytes) and %s\n", emlPath, len(result.eml), htmlPath)
+	return 0
+}
+
+// previewPage shows the headers of the rendered email above its HTML body
+// and reloads whenever /version changes
+var previewPage = htmltemplate.Must(htmltemplate.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8">
+<title>Preview: {{if .Msg}}{{.Msg.Subject}}{{else}}error{{end}}</title>
+<style>
+body { margin: 0; font-family: -apple-system, 'Segoe UI', Arial, sans-serif; background: #f3f4f6; }
+header { padding: 12px 20px; background: #fff; border-bottom: 1px solid #ddd; font-size: 14px; }
+header dl { display: grid; grid-template-columns: max-content 1fr; gap: 2px 12px; margin: 0 0 8px; }
+header dt { color: #666; }
+header dd { margin: 0; }
+iframe { display: block; width: 100%; height: calc(100vh - 160px); border: 0; background: #fff; }
+pre { margin: 20px; padding: 16px; background: #fff1f0; color: #a8071a; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+{{if .Err}}
+<pre>{{.Err}}</pre>
+{{else}}
+<header>
+<dl>
+<dt>Subject</dt><dd>{{.Msg.Subject}}</dd>
+<dt>From</dt><dd>{{.Msg.From}}</dd>
+<dt>To</dt><dd>{{range $i, $a := .Msg.To}}{{if $i}}, {{end}}{{$a}}{{end}}</dd>
+{{if .Msg.Cc}}<dt>Cc</dt><dd>{{range $i, $a := .Msg.Cc}}{{if $i}}, {{end}}{{$a}}{{end}}</dd>{{end}}
+{{if .Msg.Bcc}}<dt>Bcc</dt><dd>{{range $i, $a := .Msg.Bcc}}{{if $i}}, {{end}}{{$a}}{{end}}</dd>{{end}}
+{{if .Msg.ReplyTo}}<dt>Reply-To</dt><dd>{{range $i, $a := .Msg.ReplyTo}}{{if $i}}, {{end}}{{$a}}{{end}}</dd>{{end}}
+{{if .Msg.Attachments}}<dt>Attachments</dt><dd>{{range $i, $a := .Msg.Attachments}}{{if $i}}, {{end}}{{$a.Path}}{{end}}</dd>{{end}}
+</dl>
+<a href="/email.html" target="_blank">HTML</a> · <a href="/email.txt" target="_blank">Plain text</a> · <a href="/email.eml">Download .eml</a> ({{.Size}} bytes)
+</header>
+<iframe src="/email.html"></iframe>
+{{end}}
+<script>
+const version = {{.Version}};
+setInterval(async () => {
+  try {
+    const response = await fetch("/version");
+    if ((await response.text()) !== version) location.reload();
+  } catch (e) {}
+}, 1000);
+</script>
+</body>
+</html>
+`))
+
+// servePreview serves the email on addr, rendering it again on every request
+// so that template and data edits show up without a restart
+func servePreview(p *previewer, addr string) int {
+	var mu sync.Mutex
+	var last *rendering
+	render := func() *rendering {
+		result := p.render(func(index int, _ mailer.Attachment) string {
+			return "/image/" + strconv.Itoa(index)
+		})
+		mu.Lock()
+		last = result
+		mu.Unlock()
+		return result
+	}
+	current := func() *rendering {
+		mu.Lock()
+		defer mu.Unlock()
+		return last
+	}
+	render()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		result := render()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		previewPage.Execute(w, map[string]interface{}{
+			"Msg":     result.msg,
+			"Err":     result.err,
+			"Size":    len(result.eml),
+			"Version": result.version,
+		})
+	})
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, render().version)
+	})
+	mux.HandleFunc("/email.html", func(w http.ResponseWriter, r *http.Request) {
+		result := current()
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, result.html)
+	})
+	mux.HandleFunc("/email.txt", func(w http.ResponseWriter, r *http.Request) {
+		result := current()
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, result.msg.TextBody)
+	})
+	mux.HandleFunc("/email.eml", func(w http.ResponseWriter, r *http.Request) {
+		result := current()
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Header().Set("Content-Disposition", `attachment; filename="preview.eml"`)
+		w.Write(result.eml)
+	})
+	mux.HandleFunc("/image/", func(w http.ResponseWriter, r *http.Request) {
+		result := current()
+		index, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/image/"))
+		if result.err != nil || err != nil || index < 0 || index >= len(result.msg.InlineImages) {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, result.msg.InlineImages[index].Path)
+	})
+
+	fmt.Printf("👀 Previewing on http://%s (Ctrl+C to stop)\n", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go_mailer/api"
+	"go_mailer/config"
+	"go_mailer/scheduler"
+	"io"
+	"os"
+)
+
+// runResolve implements "go_mailer resolve": without arguments it lists the
+// jobs that were interrupted mid-send, and "go_mailer resolve <job-id>
+// sent|failed" records whether such an email arrived. The outcome is written
+// back to the job's row. The service must be stopped, since it holds the job
+// store open.
+func runResolve(args []string) int {
+	flags := flag.NewFlagSet("resolve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: go_mailer resolve [<job-id> sent|failed]")
+	}
+	flags.Parse(args)
+
+	id, outcome := flags.Arg(0), flags.Arg(1)
+	if flags.NArg() != 0 && flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	if flags.NArg() == 2 && outcome != scheduler.StatusSent && outcome != scheduler.StatusFailed {
+		fmt.Fprintf(os.Stderr, "❌ Outcome must be %s or %s, got %q\n", scheduler.StatusSent, scheduler.StatusFailed, outcome)
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to load configuration: %v\n", err)
+		return 2
+	}
+
+	emailScheduler, err := scheduler.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to open job store: %v\n", err)
+		return 1
+	}
+	defer emailScheduler.Stop()
+
+	// Jobs found interrupted while opening the store are reported to their
+	// rows, as the service would
+	source, err := api.NewRecipientSource(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to open recipient source: %v\n", err)
+		return 1
+	}
+	emailScheduler.SetStatusHook(api.SourceStatusHook(source))
+	// Sources that queue status updates send them now
+	if closer, ok := source.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	if flags.NArg() == 0 {
+		return listInterrupted(emailScheduler)
+	}
+	if err := emailScheduler.ResolveInterrupted(id, outcome == scheduler.StatusSent); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	fmt.Printf("✅ Job %s resolved as %s\n", id, outcome)
+	return 0
+}
+
+// listInterrupted prints the jobs that wait to be resolved
+func listInterrupted(emailScheduler *scheduler.Scheduler) int {
+	count := 0
+	for _, job := range emailScheduler.ListJobs() {
+		if job.Status != scheduler.StatusInterrupted {
+			continue
+		}
+		count++
+		fmt.Printf("%s  row %s  %s  %q\n", job.ID, job.RowID, job.To, job.Subject)
+	}
+	fmt.Printf("📊 %d interrupted jobs\n", count)
+	return 0
+}
@@ -0,0 +1,275 @@
+package scheduler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_mailer/logger"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Operations recorded in the file store log
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// compactMinDeadRecords is the number of superseded entries the log must hold
+// before it is compacted while open; it is also compacted only once they
+// outnumber the live jobs, so that the cost of rewriting is spread out
+const compactMinDeadRecords = 1000
+
+// fileStoreRecord is a single line in the append-only job log
+type fileStoreRecord struct {
+	Op  string    `json:"op"`
+	ID  string    `json:"id"`
+	Job *EmailJob `json:"job,omitempty"`
+}
+
+// FileStore is a JobStore backed by an append-only JSON log on disk.
+// Every change is appended as one line; the log is replayed and compacted
+// when the store is opened, and compacted again whenever superseded entries
+// pile up.
+type FileStore struct {
+	path    string
+	file    *os.File
+	jobs    map[string]*EmailJob
+	records int // Entries in the log, live or superseded
+	mu      sync.Mutex
+}
+
+// OpenFileStore opens (or creates) the job log at path and loads its jobs
+func OpenFileStore(path string) (*FileStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("error creating job store directory: %w", err)
+		}
+	}
+
+	fs := &FileStore{
+		path: path,
+		jobs: make(map[string]*EmailJob),
+	}
+
+	if err := fs.load(); err != nil {
+		return nil, err
+	}
+
+	if err := fs.compact(); err != nil {
+		return nil, err
+	}
+	if err := fs.openLog(); err != nil {
+		return nil, err
+	}
+
+	logger.Info("💾 Loaded %d jobs from job store %s", len(fs.jobs), path)
+	return fs, nil
+}
+
+// openLog opens the log for appending
+func (fs *FileStore) openLog() error {
+	file, err := os.OpenFile(fs.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening job store: %w", err)
+	}
+	fs.file = file
+	return nil
+}
+
+// load replays the log into memory
+func (fs *FileStore) load() error {
+	file, err := os.Open(fs.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening job store: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record fileStoreRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A torn write at crash time can only affect the tail of the log
+			logger.Warning("⚠️ Ignoring unreadable job store entry at %s:%d: %v", fs.path, line, err)
+			continue
+		}
+
+		switch record.Op {
+		case opPut:
+			if record.Job != nil {
+				record.Job.restoreError()
+				fs.jobs[record.ID] = record.Job
+			}
+		case opDelete:
+			delete(fs.jobs, record.ID)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading job store: %w", err)
+	}
+
+	return nil
+}
+
+// compact rewrites the log so it holds a single entry per live job
+func (fs *FileStore) compact() error {
+	tmpPath := fs.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("error creating compacted job store: %w", err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for id, job := range fs.jobs {
+		if err := encoder.Encode(fileStoreRecord{Op: opPut, ID: id, Job: job}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("error writing compacted job store: %w", err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing compacted job store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing compacted job store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing compacted job store: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, fs.path); err != nil {
+		return fmt.Errorf("error replacing job store: %w", err)
+	}
+
+	fs.records = len(fs.jobs)
+	return nil
+}
+
+// compactIfNeeded compacts the open log once enough of it is superseded. A
+// failed compaction leaves the log as it was, so it is only logged. It must
+// be called with fs.mu held.
+func (fs *FileStore) compactIfNeeded() {
+	dead := fs.records - len(fs.jobs)
+	if dead < compactMinDeadRecords || dead <= len(fs.jobs) {
+		return
+	}
+
+	if err := fs.file.Close(); err != nil {
+		logger.Warning("⚠️ Failed to close job store for compaction: %v", err)
+	}
+	fs.file = nil
+	if err := fs.compact(); err != nil {
+		logger.Warning("⚠️ Failed to compact job store %s: %v", fs.path, err)
+		os.Remove(fs.path + ".tmp")
+	} else {
+		logger.Debug("🗜️ Compacted job store %s to %d jobs, dropping %d entries", fs.path, len(fs.jobs), dead)
+	}
+	if err := fs.openLog(); err != nil {
+		logger.Error("❌ Failed to reopen job store after compaction: %v", err)
+	}
+}
+
+// append writes a record to the end of the log and flushes it to disk
+func (fs *FileStore) append(record fileStoreRecord) error {
+	if fs.file == nil {
+		return fmt.Errorf("job store %s is closed", fs.path)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding job store entry: %w", err)
+	}
+
+	data = append(data, '\n')
+	if _, err := fs.file.Write(data); err != nil {
+		return fmt.Errorf("error writing job store entry: %w", err)
+	}
+	fs.records++
+
+	return fs.file.Sync()
+}
+
+// Save appends the current state of the job to the log
+func (fs *FileStore) Save(job *EmailJob) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.append(fileStoreRecord{Op: opPut, ID: job.ID, Job: job}); err != nil {
+		return err
+	}
+
+	fs.jobs[job.ID] = job
+	fs.compactIfNeeded()
+	return nil
+}
+
+// Get returns the job with the given ID
+func (fs *FileStore) Get(id string) (*EmailJob, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	job, exists := fs.jobs[id]
+	if !exists {
+		return nil, fmt.Errorf("job with ID '%s' not found", id)
+	}
+
+	return job, nil
+}
+
+// List returns all jobs in the store
+func (fs *FileStore) List() ([]*EmailJob, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	jobs := make([]*EmailJob, 0, len(fs.jobs))
+	for _, job := range fs.jobs {
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// Delete appends a deletion entry for the job
+func (fs *FileStore) Delete(id string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.append(fileStoreRecord{Op: opDelete, ID: id}); err != nil {
+		return err
+	}
+
+	delete(fs.jobs, id)
+	fs.compactIfNeeded()
+	return nil
+}
+
+// Close closes the underlying log file
+func (fs *FileStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.file == nil {
+		return nil
+	}
+
+	err := fs.file.Close()
+	fs.file = nil
+	return err
+}
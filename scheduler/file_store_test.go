@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// logLines returns the number of entries in the log file
+func logLines(t *testing.T, path string) int {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(content, []byte("\n"))
+}
+
+func TestFileStoreCompactsWhileOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := make([]*EmailJob, 10)
+	for i := range jobs {
+		jobs[i] = &EmailJob{ID: fmt.Sprintf("job-%d", i), To: "ann@example.com", Status: StatusPending, SendAt: time.Now()}
+	}
+	// Every status change of a job appends a new entry
+	for round := 0; round < 500; round++ {
+		for _, job := range jobs {
+			job.Attempts = round
+			if err := store.Save(job); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := store.Delete("job-9"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := logLines(t, path); n > compactMinDeadRecords+2*len(jobs) {
+		t.Errorf("log holds %d entries for %d jobs after 5000 saves", n, len(jobs))
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	listed, err := reopened.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 9 {
+		t.Fatalf("reopened store has %d jobs, want 9", len(listed))
+	}
+	for _, job := range listed {
+		if job.Attempts != 499 {
+			t.Errorf("job %s reopened with attempts %d, want the last saved 499", job.ID, job.Attempts)
+		}
+	}
+}
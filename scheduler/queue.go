@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"container/heap"
+	"time"
+)
+
+// jobQueue is a min-heap of pending jobs ordered by the time they are due.
+// It implements heap.Interface; use push, remove and popDue instead of the
+// heap methods directly.
+type jobQueue struct {
+	jobs  []*EmailJob
+	index map[string]int
+}
+
+// newJobQueue creates an empty job queue
+func newJobQueue() *jobQueue {
+	return &jobQueue{
+		index: make(map[string]int),
+	}
+}
+
+func (q *jobQueue) Len() int { return len(q.jobs) }
+
+func (q *jobQueue) Less(i, j int) bool {
+	return q.jobs[i].dueAt().Before(q.jobs[j].dueAt())
+}
+
+func (q *jobQueue) Swap(i, j int) {
+	q.jobs[i], q.jobs[j] = q.jobs[j], q.jobs[i]
+	q.index[q.jobs[i].ID] = i
+	q.index[q.jobs[j].ID] = j
+}
+
+func (q *jobQueue) Push(x interface{}) {
+	job := x.(*EmailJob)
+	q.index[job.ID] = len(q.jobs)
+	q.jobs = append(q.jobs, job)
+}
+
+func (q *jobQueue) Pop() interface{} {
+	last := len(q.jobs) - 1
+	job := q.jobs[last]
+	q.jobs[last] = nil
+	q.jobs = q.jobs[:last]
+	delete(q.index, job.ID)
+	return job
+}
+
+// push adds the job to the queue, or re-orders it if it is already queued.
+// It reports whether the job became the head of the queue.
+func (q *jobQueue) push(job *EmailJob) bool {
+	if i, exists := q.index[job.ID]; exists {
+		q.jobs[i] = job
+		heap.Fix(q, i)
+	} else {
+		heap.Push(q, job)
+	}
+
+	return q.jobs[0].ID == job.ID
+}
+
+// remove drops the job with the given ID from the queue.
+// It reports whether the removed job was the head of the queue.
+func (q *jobQueue) remove(id string) bool {
+	i, exists := q.index[id]
+	if !exists {
+		return false
+	}
+
+	heap.Remove(q, i)
+	return i == 0
+}
+
+// peek returns the job that is due first, or nil if the queue is empty
+func (q *jobQueue) peek() *EmailJob {
+	if len(q.jobs) == 0 {
+		return nil
+	}
+	return q.jobs[0]
+}
+
+// popDue removes and returns every job that is due at or before now
+func (q *jobQueue) popDue(now time.Time) []*EmailJob {
+	var due []*EmailJob
+	for len(q.jobs) > 0 && !q.jobs[0].dueAt().After(now) {
+		due = append(due, heap.Pop(q).(*EmailJob))
+	}
+	return due
+}
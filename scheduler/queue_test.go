@@ -0,0 +1,145 @@
+package scheduler
+
+import (
+	"fmt"
+	"go_mailer/config"
+	"go_mailer/logger"
+	"testing"
+	"time"
+)
+
+// benchQueueSize is the number of scheduled jobs the dispatch benchmarks run
+// against
+const benchQueueSize = 100_000
+
+// benchJobs returns n pending jobs due over the day after start, in a
+// shuffled order
+func benchJobs(n int, start time.Time) []*EmailJob {
+	jobs := make([]*EmailJob, n)
+	for i := range jobs {
+		// 7919 is prime, so the offsets visit every slot once out of order
+		offset := time.Duration((i*7919)%n) * (24 * time.Hour / time.Duration(n))
+		jobs[i] = &EmailJob{
+			ID:     fmt.Sprintf("job-%d", i),
+			To:     fmt.Sprintf("user%d@example.com", i),
+			SendAt: start.Add(offset),
+			Status: StatusPending,
+		}
+	}
+	return jobs
+}
+
+// benchQueue returns a queue holding n jobs due over the day after start
+func benchQueue(n int, start time.Time) *jobQueue {
+	q := newJobQueue()
+	for _, job := range benchJobs(n, start) {
+		q.push(job)
+	}
+	return q
+}
+
+// benchBatchSize is the number of jobs BenchmarkDispatchPush queues before
+// taking them out again
+const benchBatchSize = 1000
+
+// BenchmarkDispatchPush measures queueing a job behind 100k scheduled jobs.
+// The queued jobs are removed after every batch, untimed, so the queue stays
+// at 100k jobs however large b.N grows.
+func BenchmarkDispatchPush(b *testing.B) {
+	start := time.Now()
+	q := benchQueue(benchQueueSize, start)
+	jobs := benchJobs(benchBatchSize, start)
+	for i, job := range jobs {
+		job.ID = fmt.Sprintf("new-%d", i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for done := 0; done < b.N; done += benchBatchSize {
+		batch := jobs[:min(benchBatchSize, b.N-done)]
+		for _, job := range batch {
+			q.push(job)
+		}
+
+		b.StopTimer()
+		for _, job := range batch {
+			q.remove(job.ID)
+		}
+		b.StartTimer()
+	}
+}
+
+// BenchmarkDispatchPopDue measures taking the next due job off a queue of
+// 100k scheduled jobs, putting it back a day later to keep the size steady
+func BenchmarkDispatchPopDue(b *testing.B) {
+	start := time.Now()
+	q := benchQueue(benchQueueSize, start)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		head := q.peek()
+		due := q.popDue(head.dueAt())
+		for _, job := range due {
+			job.SendAt = job.SendAt.Add(24 * time.Hour)
+			q.push(job)
+		}
+	}
+}
+
+// BenchmarkDispatchReschedule measures moving a queued job, as a retry or a
+// reschedule does, in a queue of 100k scheduled jobs
+func BenchmarkDispatchReschedule(b *testing.B) {
+	start := time.Now()
+	jobs := benchJobs(benchQueueSize, start)
+	q := newJobQueue()
+	for _, job := range jobs {
+		q.push(job)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		job := jobs[(i*7919)%len(jobs)]
+		job.NextAttemptAt = job.dueAt().Add(time.Hour)
+		q.push(job)
+	}
+}
+
+// BenchmarkDispatchProcessJobs measures the scheduler handing a due job to
+// the send workers while 100k more jobs are scheduled, including the rate
+// limiter check and the hand-off over the send queue
+func BenchmarkDispatchProcessJobs(b *testing.B) {
+	logger.SetLevel(logger.LevelError)
+	defer logger.SetLevel(logger.LevelInfo)
+
+	s, err := NewWithStore(&config.Config{}, NewMemoryStore())
+	if err != nil {
+		b.Fatal(err)
+	}
+	s.queue = benchQueue(benchQueueSize, time.Now().Add(time.Hour))
+
+	// Stand in for the send workers
+	received := make(chan struct{})
+	go func() {
+		defer close(received)
+		for i := 0; i < b.N; i++ {
+			<-s.sendQueue
+		}
+	}()
+
+	due := benchJobs(b.N, time.Now().Add(-48*time.Hour))
+	for i, job := range due {
+		job.ID = fmt.Sprintf("due-%d", i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for _, job := range due {
+		s.mu.Lock()
+		s.queue.push(job)
+		s.mu.Unlock()
+		s.processJobs()
+	}
+	<-received
+}
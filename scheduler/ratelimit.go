@@ -0,0 +1,430 @@
+package scheduler
+
+import (
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// budget limits how many recipients are sent to per window. A send to more
+// recipients than the whole budget waits until the budget is unspent and
+// then overdraws it.
+type budget interface {
+	// wait returns how long until a send to n recipients fits in the budget
+	wait(now time.Time, n int) time.Duration
+	// take counts a send to n recipients made now
+	take(now time.Time, n int)
+	// takePast counts a send to n recipients made at an earlier time
+	takePast(at, now time.Time, n int)
+	// refund gives back n recipients taken at the given time for a send
+	// that did not happen
+	refund(at time.Time, n int)
+	// usage reports how much of the budget is spent
+	usage(now time.Time) WindowUsage
+}
+
+// tokenBucket allows up to limit events per window, refilling continuously.
+// It smooths bursts but lets through up to twice the limit in a window that
+// starts with a full bucket, so it is only used for the per-minute budget.
+type tokenBucket struct {
+	limit  int
+	window time.Duration
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket
+func newTokenBucket(limit int, window time.Duration, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		limit:  limit,
+		window: window,
+		tokens: float64(limit),
+		last:   now,
+	}
+}
+
+// refill adds the tokens earned since the last update
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * float64(b.limit) / b.window.Seconds()
+		if b.tokens > float64(b.limit) {
+			b.tokens = float64(b.limit)
+		}
+		b.last = now
+	}
+}
+
+// wait returns how long until n tokens, or a full bucket if n is more than
+// it holds, are available
+func (b *tokenBucket) wait(now time.Time, n int) time.Duration {
+	b.refill(now)
+	need := float64(min(n, b.limit))
+	if b.tokens >= need {
+		return 0
+	}
+
+	missing := need - b.tokens
+	return time.Duration(missing * b.window.Seconds() / float64(b.limit) * float64(time.Second))
+}
+
+// take consumes n tokens. A bucket overdrawn by a large send refills from
+// below zero.
+func (b *tokenBucket) take(now time.Time, n int) {
+	b.refill(now)
+	b.tokens -= float64(n)
+}
+
+// takePast accounts for n events that happened at an earlier time, counting
+// only the part of them that has not refilled since
+func (b *tokenBucket) takePast(at, now time.Time, n int) {
+	b.refill(now)
+	refilled := now.Sub(at).Seconds() * float64(b.limit) / b.window.Seconds()
+	if refilled >= 1 {
+		return
+	}
+
+	b.tokens -= float64(n) * (1 - refilled)
+	if b.tokens < 0 {
+		b.tokens = 0
+	}
+}
+
+// refund returns n tokens, up to a full bucket
+func (b *tokenBucket) refund(at time.Time, n int) {
+	b.tokens += float64(n)
+	if b.tokens > float64(b.limit) {
+		b.tokens = float64(b.limit)
+	}
+}
+
+// usage reports how much of the bucket is currently spent
+func (b *tokenBucket) usage(now time.Time) WindowUsage {
+	b.refill(now)
+	remaining := max(int(b.tokens), 0)
+	return WindowUsage{
+		Window:    b.window,
+		Limit:     b.limit,
+		Used:      b.limit - remaining,
+		Remaining: remaining,
+	}
+}
+
+// slidingWindow allows at most limit events in any period of the window's
+// length, by remembering when each event in the last window happened. It
+// enforces quotas such as Gmail's daily sending limit exactly.
+type slidingWindow struct {
+	limit  int
+	window time.Duration
+	events []time.Time // Oldest first
+}
+
+// newSlidingWindow creates an empty window
+func newSlidingWindow(limit int, window time.Duration) *slidingWindow {
+	return &slidingWindow{limit: limit, window: window}
+}
+
+// prune forgets the events that left the window
+func (w *slidingWindow) prune(now time.Time) {
+	start := now.Add(-w.window)
+	expired := sort.Search(len(w.events), func(i int) bool { return w.events[i].After(start) })
+	w.events = w.events[expired:]
+}
+
+// wait returns how long until enough events leave the window for n more, or
+// until it is empty if n is more than the limit
+func (w *slidingWindow) wait(now time.Time, n int) time.Duration {
+	w.prune(now)
+	excess := len(w.events) + min(n, w.limit) - w.limit
+	if excess <= 0 {
+		return 0
+	}
+	return w.events[excess-1].Add(w.window).Sub(now)
+}
+
+// take records n events now
+func (w *slidingWindow) take(now time.Time, n int) {
+	w.prune(now)
+	for i := 0; i < n; i++ {
+		w.events = append(w.events, now)
+	}
+}
+
+// takePast records n events that happened at an earlier time, if it is
+// still inside the window
+func (w *slidingWindow) takePast(at, now time.Time, n int) {
+	w.prune(now)
+	if !at.After(now.Add(-w.window)) {
+		return
+	}
+	i := sort.Search(len(w.events), func(i int) bool { return w.events[i].After(at) })
+	w.events = append(w.events, make([]time.Time, n)...)
+	copy(w.events[i+n:], w.events[i:])
+	for j := i; j < i+n; j++ {
+		w.events[j] = at
+	}
+}
+
+// refund forgets up to n events recorded at the given time
+func (w *slidingWindow) refund(at time.Time, n int) {
+	i := sort.Search(len(w.events), func(i int) bool { return !w.events[i].Before(at) })
+	j := i
+	for j < len(w.events) && j-i < n && w.events[j].Equal(at) {
+		j++
+	}
+	w.events = append(w.events[:i], w.events[j:]...)
+}
+
+// usage reports how many events are in the window
+func (w *slidingWindow) usage(now time.Time) WindowUsage {
+	w.prune(now)
+	used := len(w.events)
+	remaining := w.limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return WindowUsage{
+		Window:    w.window,
+		Limit:     w.limit,
+		Used:      used,
+		Remaining: remaining,
+	}
+}
+
+// WindowUsage describes the send budget of a single rate limit window
+type WindowUsage struct {
+	Window    time.Duration
+	Limit     int
+	Used      int
+	Remaining int
+}
+
+// RateLimitUsage is a snapshot of the sender and per-domain send budgets
+type RateLimitUsage struct {
+	Sender  []WindowUsage
+	Domains map[string]WindowUsage
+}
+
+// String formats the usage for logging, e.g. "3/20 per 1m0s, 41/500 per 24h0m0s"
+func (u RateLimitUsage) String() string {
+	parts := make([]string, 0, len(u.Sender)+1)
+	for _, w := range u.Sender {
+		parts = append(parts, fmt.Sprintf("%d/%d per %v", w.Used, w.Limit, w.Window))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "unlimited")
+	}
+
+	domains := make([]string, 0, len(u.Domains))
+	for domain, w := range u.Domains {
+		if w.Used > 0 {
+			domains = append(domains, fmt.Sprintf("%s %d/%d", domain, w.Used, w.Limit))
+		}
+	}
+	if len(domains) > 0 {
+		sort.Strings(domains)
+		parts = append(parts, "domains: "+strings.Join(domains, ", "))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// RateLimiter enforces per-minute, per-hour and per-day send limits for the
+// sender account plus an optional per-recipient-domain limit. Every envelope
+// recipient (To, Cc and Bcc) counts as one send, as it does for providers'
+// quotas, and against the budget of its own domain. A limit of zero disables
+// that window. The per-minute limit is a token bucket; the others
+// are sliding windows, so they are never exceeded in any hour or day.
+type RateLimiter struct {
+	sender       []budget
+	domainLimit  int
+	domainWindow time.Duration
+	domains      map[string]budget
+	mu           sync.Mutex
+}
+
+// NewRateLimiter creates a rate limiter with unspent budgets
+func NewRateLimiter(perMinute, perHour, perDay, perDomainPerHour int) *RateLimiter {
+	now := time.Now()
+	r := &RateLimiter{
+		domainLimit:  perDomainPerHour,
+		domainWindow: time.Hour,
+		domains:      make(map[string]budget),
+	}
+
+	windows := []struct {
+		limit  int
+		window time.Duration
+	}{
+		{perMinute, time.Minute},
+		{perHour, time.Hour},
+		{perDay, 24 * time.Hour},
+	}
+	for _, w := range windows {
+		switch {
+		case w.limit <= 0:
+		case w.window == time.Minute:
+			r.sender = append(r.sender, newTokenBucket(w.limit, w.window, now))
+		default:
+			r.sender = append(r.sender, newSlidingWindow(w.limit, w.window))
+		}
+	}
+
+	return r
+}
+
+// envelopeAddresses returns the bare addresses of comma-separated address
+// lists, each listed once, as they are given to the SMTP server. An entry
+// that does not parse is kept as written so that it still counts.
+func envelopeAddresses(lists ...string) []string {
+	var recipients []string
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		if strings.TrimSpace(list) == "" {
+			continue
+		}
+
+		var addresses []string
+		if addrs, err := mail.ParseAddressList(list); err == nil {
+			for _, addr := range addrs {
+				addresses = append(addresses, addr.Address)
+			}
+		} else {
+			for _, address := range strings.Split(list, ",") {
+				if address = strings.TrimSpace(address); address != "" {
+					addresses = append(addresses, address)
+				}
+			}
+		}
+
+		for _, address := range addresses {
+			key := strings.ToLower(address)
+			if !seen[key] {
+				seen[key] = true
+				recipients = append(recipients, address)
+			}
+		}
+	}
+	return recipients
+}
+
+// recipientDomain returns the lower-cased domain of an email address
+func recipientDomain(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.Trim(address[at+1:], " >"))
+}
+
+// domainBudget returns the budget for a domain, creating it on first use
+func (r *RateLimiter) domainBudget(domain string) budget {
+	if r.domainLimit <= 0 || domain == "" {
+		return nil
+	}
+
+	bucket, exists := r.domains[domain]
+	if !exists {
+		bucket = newSlidingWindow(r.domainLimit, r.domainWindow)
+		r.domains[domain] = bucket
+	}
+	return bucket
+}
+
+// charges returns the budgets a send to recipients draws on, with the
+// number of recipients charged to each
+func (r *RateLimiter) charges(recipients []string) map[budget]int {
+	charges := make(map[budget]int)
+	for _, bucket := range r.sender {
+		charges[bucket] = len(recipients)
+	}
+	for _, recipient := range recipients {
+		if bucket := r.domainBudget(recipientDomain(recipient)); bucket != nil {
+			charges[bucket]++
+		}
+	}
+	return charges
+}
+
+// Reserve consumes a send to each recipient from every applicable budget. If
+// any budget is exhausted nothing is consumed and the time until it refills
+// is returned.
+func (r *RateLimiter) Reserve(recipients []string, now time.Time) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	charges := r.charges(recipients)
+	var wait time.Duration
+	for bucket, n := range charges {
+		if w := bucket.wait(now, n); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return wait, false
+	}
+
+	for bucket, n := range charges {
+		bucket.take(now, n)
+	}
+	return 0, true
+}
+
+// SenderWait returns how long until the sender account has budget for a send
+// to n recipients, ignoring per-domain limits
+func (r *RateLimiter) SenderWait(n int, now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var wait time.Duration
+	for _, bucket := range r.sender {
+		if w := bucket.wait(now, n); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// Record counts a send to recipients that happened at the given time against
+// the budgets, used to restore usage from the job store after a restart
+func (r *RateLimiter) Record(recipients []string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for bucket, n := range r.charges(recipients) {
+		bucket.takePast(at, now, n)
+	}
+}
+
+// Release gives back the budget reserved for a send to recipients at the
+// given time, for a send that was not made or that the server refused
+func (r *RateLimiter) Release(recipients []string, reservedAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for bucket, n := range r.charges(recipients) {
+		bucket.refund(reservedAt, n)
+	}
+}
+
+// Usage returns the current usage of every budget
+func (r *RateLimiter) Usage() RateLimitUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	usage := RateLimitUsage{
+		Domains: make(map[string]WindowUsage, len(r.domains)),
+	}
+	for _, bucket := range r.sender {
+		usage.Sender = append(usage.Sender, bucket.usage(now))
+	}
+	for domain, bucket := range r.domains {
+		usage.Domains[domain] = bucket.usage(now)
+	}
+
+	return usage
+}
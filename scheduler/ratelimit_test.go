@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"go_mailer/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvelopeAddresses(t *testing.T) {
+	got := envelopeAddresses("Ann <ann@a.com>, bob@b.com", "", "ANN@a.com, carol@c.com", "not an address")
+	want := "ann@a.com,bob@b.com,carol@c.com,not an address"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestDailyBudgetIsASlidingWindow(t *testing.T) {
+	r := NewRateLimiter(0, 0, 5, 0)
+	now := time.Now()
+
+	// A send every minute for two days gets five through per day
+	sent := 0
+	for i := 0; i < 2*24*60; i++ {
+		now = now.Add(time.Minute)
+		if _, ok := r.Reserve([]string{"ann@example.com"}, now); ok {
+			sent++
+		}
+	}
+	if sent != 10 {
+		t.Errorf("%d sends in two days, want 10", sent)
+	}
+
+	// Sends before a restart count against the budget
+	r = NewRateLimiter(0, 0, 5, 0)
+	now = time.Now()
+	for i := 0; i < 5; i++ {
+		r.Record([]string{"ann@example.com"}, now.Add(-time.Duration(i+1)*time.Hour))
+	}
+	wait, ok := r.Reserve([]string{"ann@example.com"}, now)
+	if ok || wait < 18*time.Hour || wait > 19*time.Hour {
+		t.Errorf("Reserve after five recorded sends = %v, %v; want to wait 19h", wait, ok)
+	}
+}
+
+func TestBudgetsCountEveryRecipient(t *testing.T) {
+	r := NewRateLimiter(0, 5, 0, 2)
+	now := time.Now()
+
+	// Three recipients in two domains
+	if _, ok := r.Reserve([]string{"ann@a.com", "bob@a.com", "carol@b.com"}, now); !ok {
+		t.Fatal("first send refused")
+	}
+	usage := r.Usage()
+	if usage.Sender[0].Used != 3 || usage.Domains["a.com"].Used != 2 || usage.Domains["b.com"].Used != 1 {
+		t.Fatalf("usage after one send to three recipients: %s", usage)
+	}
+
+	// a.com is spent, so a send to it waits although the sender has budget
+	if _, ok := r.Reserve([]string{"dave@a.com"}, now); ok {
+		t.Error("send over the a.com budget allowed")
+	}
+	if wait := r.SenderWait(1, now); wait != 0 {
+		t.Errorf("SenderWait = %v with sender budget left", wait)
+	}
+
+	// Three more recipients do not fit the hourly budget of five
+	if _, ok := r.Reserve([]string{"erin@c.com", "frank@d.com", "gina@e.com"}, now); ok {
+		t.Error("send over the sender budget allowed")
+	}
+	if _, ok := r.Reserve([]string{"erin@c.com", "frank@d.com"}, now); !ok {
+		t.Error("send within the sender budget refused")
+	}
+}
+
+func TestSendLargerThanBudgetWaitsForWholeBudget(t *testing.T) {
+	r := NewRateLimiter(3, 0, 0, 0)
+	now := time.Now()
+
+	recipients := []string{"a@x.com", "b@x.com", "c@x.com", "d@x.com", "e@x.com"}
+	if _, ok := r.Reserve(recipients, now); !ok {
+		t.Fatal("send larger than an unspent budget refused")
+	}
+	// The bucket is overdrawn by two, so filling it again takes 100 seconds
+	if wait, ok := r.Reserve(recipients, now); ok || wait < 99*time.Second || wait > 101*time.Second {
+		t.Errorf("second large send: wait %v, %v; want 100s", wait, ok)
+	}
+}
+
+func TestSchedulerChargesEnvelopeRecipients(t *testing.T) {
+	s, err := NewWithStore(&config.Config{Bcc: "archive@example.com"}, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &EmailJob{To: "ann@a.com, bob@b.com", Cc: "carol@c.com", Bcc: "dave@d.com"}
+	got := strings.Join(s.envelopeRecipients(job), ",")
+	if want := "ann@a.com,bob@b.com,carol@c.com,dave@d.com,archive@example.com"; got != want {
+		t.Errorf("envelope recipients %s, want %s", got, want)
+	}
+}
+
+func TestReleaseGivesBudgetBack(t *testing.T) {
+	r := NewRateLimiter(2, 2, 0, 1)
+	now := time.Now()
+	recipients := []string{"ann@a.com"}
+
+	if _, ok := r.Reserve(recipients, now.Add(-time.Minute)); !ok {
+		t.Fatal("first send refused")
+	}
+	if _, ok := r.Reserve(recipients, now); ok {
+		t.Fatal("send over the a.com budget allowed")
+	}
+	r.Release(recipients, now.Add(-time.Minute))
+	if _, ok := r.Reserve(recipients, now); !ok {
+		t.Errorf("send refused after the budget was given back: %s", r.Usage())
+	}
+	// Only the released reservation is given back
+	r.Release(recipients, now.Add(-time.Hour))
+	if usage := r.Usage(); usage.Sender[1].Used != 1 || usage.Domains["a.com"].Used != 1 {
+		t.Errorf("usage %s, want the remaining send counted", usage)
+	}
+}
+
+func TestStoppedDispatchGivesBudgetBack(t *testing.T) {
+	store := NewMemoryStore()
+	if err := store.Save(&EmailJob{ID: "due", To: "ann@example.com", Status: StatusPending, SendAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewWithStore(&config.Config{RateLimitPerHour: 10}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With the scheduler stopped no worker takes the job, so it goes back
+	// to the queue unsent
+	close(s.stopChan)
+	s.processJobs()
+
+	if used := s.RateLimitUsage().Sender[0].Used; used != 0 {
+		t.Errorf("%d sends counted for a job that was not sent", used)
+	}
+	if s.queue.Len() != 1 {
+		t.Errorf("queue holds %d jobs, want the job back", s.queue.Len())
+	}
+}
+
+func TestFailedSendGivesBudgetBack(t *testing.T) {
+	store := NewMemoryStore()
+	if err := store.Save(&EmailJob{ID: "due", To: "ann@example.com", Status: StatusPending, SendAt: time.Now().Add(-time.Minute), MaxAttempts: 1}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewWithStore(&config.Config{RateLimitPerHour: 10}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	job := s.queue.popDue(now)[0]
+	if _, ok := s.limiter.Reserve(s.envelopeRecipients(job), now); !ok {
+		t.Fatal("send refused")
+	}
+	job.reservedAt = now
+
+	// The job has no template, so building the email fails before sending
+	if !s.claimJob(job) {
+		t.Fatal("job not claimed")
+	}
+	s.sendJob(job)
+	if job.Status != StatusFailed {
+		t.Fatalf("job is %s, want failed", job.Status)
+	}
+	if used := s.RateLimitUsage().Sender[0].Used; used != 0 {
+		t.Errorf("%d sends counted for an email that failed", used)
+	}
+}
@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"time"
+)
+
+// isTransientError reports whether a failed send is worth retrying.
+// SMTP 4xx replies, timeouts and dropped connections are transient; SMTP 5xx
+// replies (bad address, rejected message) and template errors are permanent.
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isBounce reports whether a permanent failure means the recipient's server
+// rejected the recipient or the message (SMTP 550 to 554), as opposed to a
+// problem on the sending side such as failed authentication
+func isBounce(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 550 && protoErr.Code <= 554
+}
+
+// RetryDelay returns the jittered exponential backoff before the next attempt,
+// where attempt is the number of attempts made so far
+func RetryDelay(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	// Equal jitter: keep half of the delay and randomise the other half so that
+	// callers failing together do not retry in lockstep
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
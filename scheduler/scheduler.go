@@ -1,48 +1,244 @@
 package scheduler
 
 import (
+	"errors"
 	"fmt"
 	"go_mailer/config"
 	"go_mailer/logger"
 	"go_mailer/mailer"
 	"go_mailer/template"
+	"net/mail"
+	"strings"
 	"sync"
 	"time"
 )
 
+// Job statuses
+const (
+	StatusPending   = "pending"
+	StatusSending   = "sending"
+	StatusSent      = "sent"
+	StatusFailed    = "failed"
+	StatusBounced   = "bounced" // Rejected by the recipient's server, or bounced after sending
+	StatusCancelled = "cancelled"
+
+	// StatusInterrupted marks a job that was being sent when the process
+	// stopped. Whether the email went out is unknown, so it is never sent
+	// again automatically.
+	StatusInterrupted = "interrupted"
+)
+
+// errInterrupted is the error recorded on interrupted jobs
+var errInterrupted = errors.New("interrupted while sending; delivery state unknown")
+
 // EmailJob represents a scheduled email job
 type EmailJob struct {
 	ID           string
-	To           string
-	Subject      string
+	RowID        string // The recipient source row the job was built from, if any
+	To           string // Comma-separated address list
+	Cc           string
+	Bcc          string
+	ReplyTo      string
+	Subject      string // Rendered from the template's subject when empty
 	TemplatePath string
 	TemplateData template.TemplateData
+	Attachments  []mailer.Attachment
 	SendAt       time.Time
-	Status       string // "pending", "sent", "failed"
-	Error        error
+	Status       string // One of the Status constants
+	SentAt       time.Time
+	MessageID    string // Message-ID header of the sent email
+	Error        error  `json:"-"`
+	ErrorMessage string // Persisted form of Error
+
+	// Retry bookkeeping
+	Attempts      int       // Number of send attempts made so far
+	MaxAttempts   int       // Attempts allowed before the job is marked failed
+	NextAttemptAt time.Time // Earliest time of the next retry, zero until a retry is scheduled
+
+	reservedAt time.Time // When send budget was reserved for the current attempt
+}
+
+// Email returns the email the job sends
+func (j *EmailJob) Email() *mailer.Email {
+	return &mailer.Email{
+		To:           j.To,
+		Cc:           j.Cc,
+		Bcc:          j.Bcc,
+		ReplyTo:      j.ReplyTo,
+		Subject:      j.Subject,
+		TemplatePath: j.TemplatePath,
+		TemplateData: j.TemplateData,
+		Attachments:  j.Attachments,
+	}
+}
+
+// Unresolved reports whether the job may still send its email, or may have
+// sent it without the outcome being known: it is pending, being sent, or was
+// interrupted mid-send. A new email to the same recipient row would risk a
+// duplicate.
+func (j *EmailJob) Unresolved() bool {
+	switch j.Status {
+	case StatusPending, StatusSending, StatusInterrupted:
+		return true
+	}
+	return false
+}
+
+// dueAt returns the time at which the job should next be attempted
+func (j *EmailJob) dueAt() time.Time {
+	if j.NextAttemptAt.After(j.SendAt) {
+		return j.NextAttemptAt
+	}
+	return j.SendAt
+}
+
+// setError records err on the job in both its live and persisted form
+func (j *EmailJob) setError(err error) {
+	j.Error = err
+	j.ErrorMessage = ""
+	if err != nil {
+		j.ErrorMessage = err.Error()
+	}
 }
 
-// EmailCallback is a function that is called when an email is sent
+// restoreError rebuilds Error from its persisted message after loading
+func (j *EmailJob) restoreError() {
+	if j.ErrorMessage != "" {
+		j.Error = errors.New(j.ErrorMessage)
+	}
+}
+
+// EmailCallback is a function that is called once an email is sent or has
+// definitively failed after all retries
 type EmailCallback func(successful bool)
 
+// StatusHook is called with a copy of a job whenever its status changes:
+// when it is scheduled, rescheduled after a failed attempt, sent, failed,
+// bounced, cancelled or found interrupted on start
+type StatusHook func(job EmailJob)
+
 // Scheduler manages scheduled email jobs
 type Scheduler struct {
-	mailClient *mailer.Mailer
-	jobs       map[string]*EmailJob
-	callbacks  map[string]EmailCallback
-	mu         sync.RWMutex
-	stopChan   chan struct{}
-	wg         sync.WaitGroup
+	mailClient     *mailer.Mailer
+	store          JobStore
+	queue          *jobQueue
+	sendQueue      chan *EmailJob
+	workers        int
+	limiter        *RateLimiter
+	throttledUntil time.Time
+	callbacks      map[string]EmailCallback
+	statusHook     StatusHook
+	unreported     []EmailJob // Status changes made on start, before a hook was set
+	maxAttempts    int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+	maxAttachSize  int64
+	bcc            string // Blind-copied on every email by the mailer
+	mu             sync.RWMutex
+	wakeChan       chan struct{}
+	stopChan       chan struct{}
+	wg             sync.WaitGroup
+}
+
+// New creates a new Scheduler instance backed by the job store configured in cfg
+func New(cfg *config.Config) (*Scheduler, error) {
+	var store JobStore
+	if cfg.JobStorePath == "memory" {
+		store = NewMemoryStore()
+	} else {
+		fileStore, err := OpenFileStore(cfg.JobStorePath)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+
+	return NewWithStore(cfg, store)
 }
 
-// New creates a new Scheduler instance
-func New(cfg *config.Config) *Scheduler {
-	return &Scheduler{
-		mailClient: mailer.New(cfg),
-		jobs:       make(map[string]*EmailJob),
-		callbacks:  make(map[string]EmailCallback),
-		stopChan:   make(chan struct{}),
+// NewWithStore creates a new Scheduler instance that keeps its jobs in store
+func NewWithStore(cfg *config.Config, store JobStore) (*Scheduler, error) {
+	s := &Scheduler{
+		mailClient:     mailer.New(cfg),
+		store:          store,
+		queue:          newJobQueue(),
+		sendQueue:      make(chan *EmailJob),
+		workers:        cfg.MaxConcurrentSends,
+		limiter:        NewRateLimiter(cfg.RateLimitPerMinute, cfg.RateLimitPerHour, cfg.RateLimitPerDay, cfg.DomainRateLimitPerHour),
+		callbacks:      make(map[string]EmailCallback),
+		maxAttempts:    cfg.MaxSendAttempts,
+		retryBaseDelay: cfg.RetryBaseDelay,
+		retryMaxDelay:  cfg.RetryMaxDelay,
+		maxAttachSize:  cfg.MaxAttachmentSize,
+		bcc:            cfg.Bcc,
+		wakeChan:       make(chan struct{}, 1),
+		stopChan:       make(chan struct{}),
+	}
+	if s.maxAttempts < 1 {
+		s.maxAttempts = 1
+	}
+	if s.workers < 1 {
+		s.workers = 1
+	}
+
+	if err := s.recoverJobs(); err != nil {
+		return nil, err
 	}
+
+	return s, nil
+}
+
+// recoverJobs resolves jobs that were being sent when the process last stopped
+// and queues the pending ones. Delivery of interrupted emails is unknown, so
+// they are marked interrupted instead of being sent a second time.
+func (s *Scheduler) recoverJobs() error {
+	jobs, err := s.store.List()
+	if err != nil {
+		return fmt.Errorf("error loading jobs from store: %w", err)
+	}
+
+	counts := make(map[string]int)
+	for _, job := range jobs {
+		if job.Status == StatusSending {
+			logger.Warning("⚠️ Job '%s' to %s was interrupted mid-send, marking as interrupted instead of resending", job.ID, job.To)
+			job.Status = StatusInterrupted
+			job.setError(errInterrupted)
+			if err := s.store.Save(job); err != nil {
+				return fmt.Errorf("error resolving interrupted job '%s': %w", job.ID, err)
+			}
+			s.unreported = append(s.unreported, *job)
+		}
+		if job.Status == StatusPending {
+			s.queue.push(job)
+		}
+		if job.Status == StatusSent && !job.SentAt.IsZero() {
+			s.limiter.Record(s.envelopeRecipients(job), job.SentAt)
+		}
+		counts[job.Status]++
+	}
+
+	if len(jobs) > 0 {
+		logger.Info("♻️ Recovered %d jobs (%d pending, %d sent, %d failed, %d interrupted)",
+			len(jobs), counts[StatusPending], counts[StatusSent], counts[StatusFailed], counts[StatusInterrupted])
+	}
+
+	return nil
+}
+
+// releaseBudget gives back the send budget reserved for the job's current
+// attempt when the email was not sent
+func (s *Scheduler) releaseBudget(job *EmailJob) {
+	if job.reservedAt.IsZero() {
+		return
+	}
+	s.limiter.Release(s.envelopeRecipients(job), job.reservedAt)
+	job.reservedAt = time.Time{}
+}
+
+// envelopeRecipients returns every address the job's email is delivered to,
+// which is what the send budgets count
+func (s *Scheduler) envelopeRecipients(job *EmailJob) []string {
+	return envelopeAddresses(job.To, job.Cc, job.Bcc, s.bcc)
 }
 
 // RegisterCallback registers a callback function for a specific job
@@ -50,8 +246,7 @@ func (s *Scheduler) RegisterCallback(jobID string, callback EmailCallback) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, exists := s.jobs[jobID]
-	if !exists {
+	if _, err := s.store.Get(jobID); err != nil {
 		logger.Warning("⚠️ Warning: Trying to register callback for non-existent job ID: %s", jobID)
 		return
 	}
@@ -59,27 +254,132 @@ func (s *Scheduler) RegisterCallback(jobID string, callback EmailCallback) {
 	s.callbacks[jobID] = callback
 }
 
+// HasCallback reports whether a callback is registered for the job.
+// Jobs recovered from the store after a restart start without one.
+func (s *Scheduler) HasCallback(jobID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, exists := s.callbacks[jobID]
+	return exists
+}
+
+// SetStatusHook sets the hook called on every job status change, replacing
+// any previous one. Jobs marked interrupted while the scheduler was created
+// are reported to the first hook set, before SetStatusHook returns.
+func (s *Scheduler) SetStatusHook(hook StatusHook) {
+	s.mu.Lock()
+	s.statusHook = hook
+	var unreported []EmailJob
+	if hook != nil {
+		unreported, s.unreported = s.unreported, nil
+	}
+	s.mu.Unlock()
+
+	for _, job := range unreported {
+		hook(job)
+	}
+}
+
+// statusChange snapshots the job for the status hook. It must be called with
+// s.mu held and the returned function called after releasing it.
+func (s *Scheduler) statusChange(job *EmailJob) func() {
+	hook := s.statusHook
+	if hook == nil {
+		return func() {}
+	}
+	snapshot := *job
+	return func() { hook(snapshot) }
+}
+
 // ScheduleEmail schedules an email to be sent at a specific time
 func (s *Scheduler) ScheduleEmail(to, subject, templatePath string, templateData template.TemplateData, sendAt time.Time) (string, error) {
-	// Generate a unique ID for the job
-	id := fmt.Sprintf("job-%d", time.Now().UnixNano())
-
-	job := &EmailJob{
-		ID:           id,
+	return s.ScheduleJob(&EmailJob{
 		To:           to,
 		Subject:      subject,
 		TemplatePath: templatePath,
 		TemplateData: templateData,
 		SendAt:       sendAt,
-		Status:       "pending",
+	})
+}
+
+// ScheduleJob schedules a job built by the caller. The ID, status and retry
+// bookkeeping are filled in by the scheduler. Attachments and the fields the
+// template uses are checked now so that a missing file or data field is
+// reported before the send time.
+func (s *Scheduler) ScheduleJob(job *EmailJob) (string, error) {
+	if err := validateAddresses(job); err != nil {
+		return "", err
+	}
+	if err := mailer.ValidateAttachments(job.Attachments, s.maxAttachSize); err != nil {
+		return "", fmt.Errorf("invalid attachments: %w", err)
+	}
+	// Catch fields the template uses but the data lacks now rather than
+	// when the job comes due
+	missing, err := template.MissingFields(job.TemplatePath, job.TemplateData)
+	if err != nil {
+		return "", fmt.Errorf("error checking template: %w", err)
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("template %s uses fields missing from the data: %s", job.TemplatePath, strings.Join(missing, ", "))
+	}
+	if job.Subject == "" {
+		// The subject is rendered from the template at send time, so that
+		// edits to it apply; check now that there is one
+		if _, ok, err := template.ProcessSubject(job.TemplatePath, job.TemplateData); err != nil {
+			return "", fmt.Errorf("error rendering subject: %w", err)
+		} else if !ok {
+			return "", fmt.Errorf("no subject given and template %s defines none", job.TemplatePath)
+		}
 	}
 
+	// Generate a unique ID for the job
+	job.ID = fmt.Sprintf("job-%d", time.Now().UnixNano())
+	job.Status = StatusPending
+	job.MaxAttempts = s.maxAttempts
+	job.Attempts = 0
+	job.NextAttemptAt = time.Time{}
+
 	s.mu.Lock()
-	s.jobs[id] = job
+	err = s.store.Save(job)
+	if err != nil {
+		s.mu.Unlock()
+		return "", fmt.Errorf("error saving job: %w", err)
+	}
+	if s.queue.push(job) {
+		s.wake()
+	}
+	notify := s.statusChange(job)
 	s.mu.Unlock()
+
 	ist := time.FixedZone("IST", 5*60*60+30*60)
-	logger.Info("📋 Email job created with ID '%s' to %s scheduled for %s", id, to, sendAt.In(ist).Format("2006-01-02 15:04:05"))
-	return id, nil
+	logger.Info("📋 Email job created with ID '%s' to %s scheduled for %s", job.ID, job.To, job.SendAt.In(ist).Format("2006-01-02 15:04:05"))
+	notify()
+	return job.ID, nil
+}
+
+// validateAddresses checks the job's address lists so that a typo is
+// reported when scheduling rather than at send time
+func validateAddresses(job *EmailJob) error {
+	if _, err := mail.ParseAddressList(job.To); err != nil {
+		return fmt.Errorf("invalid recipient address %q: %w", job.To, err)
+	}
+
+	optional := []struct{ field, list string }{
+		{"cc", job.Cc},
+		{"bcc", job.Bcc},
+		{"reply-to", job.ReplyTo},
+	}
+	for _, o := range optional {
+		if strings.TrimSpace(o.list) == "" {
+			continue
+		}
+		if _, err := mail.ParseAddressList(o.list); err != nil {
+			return fmt.Errorf("invalid %s address %q: %w", o.field, o.list, err)
+		}
+	}
+
+	return nil
 }
 
 // GetJob retrieves information about a specific job
@@ -87,12 +387,7 @@ func (s *Scheduler) GetJob(id string) (*EmailJob, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	job, exists := s.jobs[id]
-	if !exists {
-		return nil, fmt.Errorf("job with ID '%s' not found", id)
-	}
-
-	return job, nil
+	return s.store.Get(id)
 }
 
 // ListJobs returns all scheduled jobs
@@ -100,47 +395,156 @@ func (s *Scheduler) ListJobs() []*EmailJob {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	jobs := make([]*EmailJob, 0, len(s.jobs))
-	for _, job := range s.jobs {
-		jobs = append(jobs, job)
+	jobs, err := s.store.List()
+	if err != nil {
+		logger.Error("❌ Failed to list jobs: %v", err)
+		return nil
 	}
 
 	return jobs
 }
 
-// CancelJob cancels a scheduled job
+// CancelJob cancels a scheduled job. The job is kept with the cancelled
+// status.
 func (s *Scheduler) CancelJob(id string) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
-	job, exists := s.jobs[id]
-	if !exists {
-		return fmt.Errorf("job with ID '%s' not found", id)
+	job, err := s.store.Get(id)
+	if err != nil {
+		s.mu.Unlock()
+		return err
 	}
 
-	if job.Status != "pending" {
+	if job.Status != StatusPending {
+		s.mu.Unlock()
 		return fmt.Errorf("job with ID '%s' has already been processed (status: %s)", id, job.Status)
 	}
 
-	delete(s.jobs, id)
+	job.Status = StatusCancelled
+	if err := s.store.Save(job); err != nil {
+		job.Status = StatusPending
+		s.mu.Unlock()
+		return fmt.Errorf("error cancelling job '%s': %w", id, err)
+	}
+	delete(s.callbacks, id)
+	if s.queue.remove(id) {
+		s.wake()
+	}
+	notify := s.statusChange(job)
+	s.mu.Unlock()
+
 	logger.Info("Job with ID '%s' has been cancelled", id)
+	notify()
+	return nil
+}
+
+// MarkBounced records that a sent email bounced, for bounce notifications
+// that arrive after the send
+func (s *Scheduler) MarkBounced(id string, reason string) error {
+	s.mu.Lock()
+
+	job, err := s.store.Get(id)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if job.Status != StatusSent {
+		s.mu.Unlock()
+		return fmt.Errorf("job with ID '%s' was not sent (status: %s)", id, job.Status)
+	}
+
+	job.Status = StatusBounced
+	job.setError(errors.New(reason))
+	if err := s.store.Save(job); err != nil {
+		logger.Error("❌ Failed to persist status of job '%s': %v", job.ID, err)
+	}
+	notify := s.statusChange(job)
+	s.mu.Unlock()
+
+	logger.Warning("↩️ Email '%s' to %s bounced: %s", id, job.To, reason)
+	notify()
+	return nil
+}
+
+// ResolveInterrupted records the outcome of an interrupted job once it is
+// known, for example after checking the recipient's inbox or the sender's
+// sent folder. A job resolved as sent is done; one resolved as failed is
+// scheduled again by the next check of its recipient source.
+func (s *Scheduler) ResolveInterrupted(id string, sent bool) error {
+	s.mu.Lock()
+
+	job, err := s.store.Get(id)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if job.Status != StatusInterrupted {
+		s.mu.Unlock()
+		return fmt.Errorf("job with ID '%s' was not interrupted (status: %s)", id, job.Status)
+	}
+
+	job.Status = StatusFailed
+	if sent {
+		job.Status = StatusSent
+		job.setError(nil)
+	}
+	if err := s.store.Save(job); err != nil {
+		job.Status = StatusInterrupted
+		job.setError(errInterrupted)
+		s.mu.Unlock()
+		return fmt.Errorf("error resolving job '%s': %w", id, err)
+	}
+	notify := s.statusChange(job)
+	s.mu.Unlock()
+
+	logger.Info("Interrupted job '%s' to %s resolved as %s", id, job.To, job.Status)
+	notify()
 	return nil
 }
 
-// Start starts the scheduler
+// Start starts the scheduler. A single timer is armed for the job that is due
+// first and re-armed whenever the head of the queue changes; due jobs are
+// handed to a fixed pool of send workers.
 func (s *Scheduler) Start() {
-	logger.Info("▶️ Email scheduler started")
+	logger.Info("▶️ Email scheduler started with %d send workers", s.workers)
+
+	for i := 0; i < s.workers; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
 
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
-		ticker := time.NewTicker(20 * time.Second)
-		defer ticker.Stop()
+		timer := time.NewTimer(time.Hour)
+		defer timer.Stop()
 
 		for {
+			// Stop takes priority over due jobs
 			select {
-			case <-ticker.C:
+			case <-s.stopChan:
+				return
+			default:
+			}
+
+			// Re-arm the timer for the job at the head of the queue
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+
+			var timerChan <-chan time.Time
+			if wait, ok := s.nextWait(); ok {
+				timer.Reset(wait)
+				timerChan = timer.C
+			}
+
+			select {
+			case <-timerChan:
 				s.processJobs()
+			case <-s.wakeChan:
 			case <-s.stopChan:
 				return
 			}
@@ -148,63 +552,115 @@ func (s *Scheduler) Start() {
 	}()
 }
 
-// Stop stops the scheduler
+// nextWait returns how long until the next job is due, or until the send budget
+// refills if dispatch is paused, and false if no job is queued
+func (s *Scheduler) nextWait() (time.Duration, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	next := s.queue.peek()
+	if next == nil {
+		return 0, false
+	}
+
+	due := next.dueAt()
+	if s.throttledUntil.After(due) {
+		due = s.throttledUntil
+	}
+
+	wait := time.Until(due)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+// wake interrupts the dispatch loop so that it re-reads the head of the queue
+func (s *Scheduler) wake() {
+	select {
+	case s.wakeChan <- struct{}{}:
+	default:
+	}
+}
+
+// Stop stops the scheduler. Sends that are already in progress, and their
+// callbacks, are allowed to finish; queued jobs stay pending in the job store.
 func (s *Scheduler) Stop() {
 	logger.Info("⏹️ Stopping email scheduler...")
 	close(s.stopChan)
 	s.wg.Wait()
+	s.mailClient.Close()
+	if err := s.store.Close(); err != nil {
+		logger.Error("❌ Failed to close job store: %v", err)
+	}
 	logger.Info("✅ Email scheduler stopped")
 }
 
-// processJobs processes jobs that are due
+// processJobs hands the jobs that are due to the send workers, blocking while
+// all workers are busy
 func (s *Scheduler) processJobs() {
 	ist := time.FixedZone("IST", 5*60*60+30*60)
 	now := time.Now().In(ist)
+
+	s.mu.Lock()
 	var jobsToProcess []*EmailJob
+	dueJobs := s.queue.popDue(now)
+	for i, job := range dueJobs {
+		recipients := s.envelopeRecipients(job)
+		if wait, ok := s.limiter.Reserve(recipients, now); !ok {
+			if senderWait := s.limiter.SenderWait(len(recipients), now); senderWait > 0 {
+				// The account budget is spent: pause dispatch until it refills
+				s.throttledUntil = now.Add(senderWait)
+				logger.Info("🚦 Send budget exhausted (%s), pausing %d due emails for %v",
+					s.limiter.Usage(), len(dueJobs)-i, senderWait.Round(time.Second))
+				for _, j := range dueJobs[i:] {
+					s.queue.push(j)
+				}
+				break
+			}
 
-	// First, find jobs that need to be processed
-	s.mu.RLock()
-	for _, job := range s.jobs {
-		if job.Status == "pending" && now.After(job.SendAt) {
-			jobsToProcess = append(jobsToProcess, job)
+			// Only a recipient domain is over budget: defer just this job
+			job.NextAttemptAt = now.Add(wait)
+			logger.Info("🚦 Domain send budget exhausted, deferring email '%s' to %s by %v",
+				job.ID, job.To, wait.Round(time.Second))
+			if err := s.store.Save(job); err != nil {
+				logger.Error("❌ Failed to persist deferral of job '%s': %v", job.ID, err)
+			}
+			s.queue.push(job)
+			continue
 		}
+		job.reservedAt = now
+		jobsToProcess = append(jobsToProcess, job)
 	}
-	s.mu.RUnlock()
+	s.mu.Unlock()
 
 	if len(jobsToProcess) > 0 {
 		logger.Info("⏱️ Processing %d due email jobs", len(jobsToProcess))
 	}
 
-	// Process each job
-	for _, job := range jobsToProcess {
-		go func(j *EmailJob) {
-			logger.Info("📤 Processing email to %s (Job ID: %s)", j.To, j.ID)
-
-			// Send the email
-			err := s.mailClient.SendWithTemplate(j.To, j.Subject, j.TemplatePath, j.TemplateData)
-
-			// Update job status
+	for i, job := range jobsToProcess {
+		select {
+		case s.sendQueue <- job:
+		case <-s.stopChan:
+			// Put back what the workers never picked up
 			s.mu.Lock()
-			var successful bool
-			if err != nil {
-				j.Status = "failed"
-				j.Error = err
-				logger.Error("❌ Failed to send email '%s' to %s: %v", j.ID, j.To, err)
-				successful = false
-			} else {
-				j.Status = "sent"
-				logger.Info("✅ Email '%s' to %s sent successfully", j.ID, j.To)
-				successful = true
+			for _, j := range jobsToProcess[i:] {
+				s.releaseBudget(j)
+				s.queue.push(j)
 			}
-
-			// Get the callback if it exists
-			callback, hasCallback := s.callbacks[j.ID]
 			s.mu.Unlock()
-
-			// Execute the callback if it exists
-			if hasCallback {
-				go callback(successful)
-			}
-		}(job)
+			return
+		}
 	}
 }
+
+// RateLimitUsage returns the current usage of the sender and per-domain send budgets
+func (s *Scheduler) RateLimitUsage() RateLimitUsage {
+	return s.limiter.Usage()
+}
+
+// shouldRetry reports whether a failed job has attempts left and failed
+// with an error that is worth retrying
+func (s *Scheduler) shouldRetry(j *EmailJob, err error) bool {
+	return j.Attempts < j.MaxAttempts && isTransientError(err)
+}
@@ -0,0 +1,77 @@
+package scheduler
+
+import (
+	"go_mailer/config"
+	"testing"
+	"time"
+)
+
+func TestInterruptedJobsReachStatusHook(t *testing.T) {
+	store := NewMemoryStore()
+	for _, job := range []*EmailJob{
+		{ID: "sending", RowID: "7", To: "ann@example.com", Status: StatusSending, SendAt: time.Now()},
+		{ID: "pending", RowID: "8", To: "bob@example.com", Status: StatusPending, SendAt: time.Now().Add(time.Hour)},
+	} {
+		if err := store.Save(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := NewWithStore(&config.Config{}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reported []EmailJob
+	s.SetStatusHook(func(job EmailJob) { reported = append(reported, job) })
+	if len(reported) != 1 || reported[0].ID != "sending" || reported[0].Status != StatusInterrupted || reported[0].RowID != "7" {
+		t.Fatalf("reported %+v, want the interrupted job", reported)
+	}
+
+	// Replacing the hook does not report the jobs again
+	reported = nil
+	s.SetStatusHook(func(job EmailJob) { reported = append(reported, job) })
+	if len(reported) != 0 {
+		t.Errorf("reported %d jobs again", len(reported))
+	}
+}
+
+func TestResolveInterrupted(t *testing.T) {
+	store := NewMemoryStore()
+	for _, id := range []string{"arrived", "lost"} {
+		if err := store.Save(&EmailJob{ID: id, RowID: id, To: "ann@example.com", Status: StatusSending, SendAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := NewWithStore(&config.Config{}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reported []EmailJob
+	s.SetStatusHook(func(job EmailJob) { reported = append(reported, job) })
+	reported = nil
+
+	if err := s.ResolveInterrupted("arrived", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ResolveInterrupted("lost", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ResolveInterrupted("arrived", false); err == nil {
+		t.Error("resolved a job that is no longer interrupted")
+	}
+
+	for id, want := range map[string]string{"arrived": StatusSent, "lost": StatusFailed} {
+		job, err := s.GetJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != want || job.Unresolved() {
+			t.Errorf("job %s is %s (unresolved %v), want %s", id, job.Status, job.Unresolved(), want)
+		}
+	}
+	if len(reported) != 2 || reported[0].Status != StatusSent || reported[1].Status != StatusFailed {
+		t.Errorf("reported %+v, want sent then failed", reported)
+	}
+}
@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"fmt"
+	"sync"
+)
+
+// JobStore persists email jobs so that scheduled work survives restarts
+type JobStore interface {
+	// Save inserts the job or replaces the stored copy with the same ID
+	Save(job *EmailJob) error
+
+	// Get returns the job with the given ID
+	Get(id string) (*EmailJob, error)
+
+	// List returns every stored job regardless of status
+	List() ([]*EmailJob, error)
+
+	// Delete removes the job with the given ID
+	Delete(id string) error
+
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// MemoryStore is a JobStore that keeps jobs in memory only
+type MemoryStore struct {
+	jobs map[string]*EmailJob
+	mu   sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory job store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs: make(map[string]*EmailJob),
+	}
+}
+
+// Save stores the job in memory
+func (m *MemoryStore) Save(job *EmailJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jobs[job.ID] = job
+	return nil
+}
+
+// Get returns the job with the given ID
+func (m *MemoryStore) Get(id string) (*EmailJob, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, exists := m.jobs[id]
+	if !exists {
+		return nil, fmt.Errorf("job with ID '%s' not found", id)
+	}
+
+	return job, nil
+}
+
+// List returns all jobs held in memory
+func (m *MemoryStore) List() ([]*EmailJob, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	jobs := make([]*EmailJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// Delete removes the job with the given ID
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.jobs, id)
+	return nil
+}
+
+// Close is a no-op for the in-memory store
+func (m *MemoryStore) Close() error {
+	return nil
+}
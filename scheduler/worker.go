@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"go_mailer/logger"
+	"time"
+)
+
+// worker sends jobs from the send queue until the scheduler is stopped
+func (s *Scheduler) worker() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case job := <-s.sendQueue:
+			if s.claimJob(job) {
+				s.sendJob(job)
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// claimJob marks the job as being sent so that a crash during the send can be
+// detected on the next start. It returns false if the job was cancelled while
+// it was waiting for a worker or could not be claimed.
+func (s *Scheduler) claimJob(job *EmailJob) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.store.Get(job.ID); err != nil || job.Status != StatusPending {
+		logger.Info("⏭️ Skipping job '%s', it was cancelled before sending", job.ID)
+		s.releaseBudget(job)
+		return false
+	}
+
+	job.Status = StatusSending
+	job.Attempts++
+	if err := s.store.Save(job); err != nil {
+		logger.Error("❌ Failed to claim job '%s', retrying later: %v", job.ID, err)
+		job.Status = StatusPending
+		job.Attempts--
+		job.NextAttemptAt = time.Now().Add(s.retryBaseDelay)
+		s.releaseBudget(job)
+		if s.queue.push(job) {
+			s.wake()
+		}
+		return false
+	}
+
+	return true
+}
+
+// sendJob sends a claimed job and records the outcome
+func (s *Scheduler) sendJob(j *EmailJob) {
+	logger.Info("📤 Processing email to %s (Job ID: %s)", j.To, j.ID)
+
+	// Send the email
+	messageID, err := s.mailClient.Send(j.Email())
+
+	// Update job status
+	s.mu.Lock()
+	var successful bool
+	if err != nil {
+		// The server did not take the email, so it does not count
+		s.releaseBudget(j)
+		j.setError(err)
+		if s.shouldRetry(j, err) {
+			delay := RetryDelay(j.Attempts, s.retryBaseDelay, s.retryMaxDelay)
+			j.Status = StatusPending
+			j.NextAttemptAt = time.Now().Add(delay)
+			logger.Warning("🔁 Transient failure sending email '%s' to %s (attempt %d/%d), retrying in %v: %v",
+				j.ID, j.To, j.Attempts, j.MaxAttempts, delay.Round(time.Second), err)
+			if err := s.store.Save(j); err != nil {
+				logger.Error("❌ Failed to persist status of job '%s': %v", j.ID, err)
+			}
+			if s.queue.push(j) {
+				s.wake()
+			}
+			notify := s.statusChange(j)
+			s.mu.Unlock()
+			notify()
+			return
+		}
+
+		j.Status = StatusFailed
+		if isBounce(err) {
+			j.Status = StatusBounced
+		}
+		logger.Error("❌ Failed to send email '%s' to %s after %d attempt(s): %v", j.ID, j.To, j.Attempts, err)
+		successful = false
+	} else {
+		j.Status = StatusSent
+		j.SentAt = time.Now()
+		j.MessageID = messageID
+		j.setError(nil)
+		logger.Info("✅ Email '%s' to %s sent successfully", j.ID, j.To)
+		successful = true
+	}
+
+	if err := s.store.Save(j); err != nil {
+		logger.Error("❌ Failed to persist status of job '%s': %v", j.ID, err)
+	}
+
+	// Get the callback if it exists
+	callback, hasCallback := s.callbacks[j.ID]
+	notify := s.statusChange(j)
+	s.mu.Unlock()
+
+	notify()
+
+	// Execute the callback if it exists; it is tracked so Stop waits for it
+	if hasCallback {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			callback(successful)
+		}()
+	}
+}
@@ -1,5 +1,14 @@
 package template
 
+// Template discovery
+const (
+	// DefaultTemplateDir is the directory searched for templates
+	DefaultTemplateDir = "tamplets"
+
+	// DefaultTemplateName is used when a sheet row names no template
+	DefaultTemplateName = "email"
+)
+
 // Template paths
 const (
 	// DefaultEmailTemplate is the default path to the email template
@@ -0,0 +1,515 @@
+package template
+
+import (
+	"html"
+	"sort"
+	"strings"
+)
+
+// cssDeclaration is a single "property: value" pair
+type cssDeclaration struct {
+	Property  string
+	Value     string
+	Important bool
+}
+
+// cssSelector is a selector such as "div.header > h1", stored as compound
+// selectors with the combinator that precedes each one
+type cssSelector struct {
+	parts       []cssCompound
+	specificity [3]int // IDs, classes and attributes, type selectors
+}
+
+// cssCompound is a compound selector such as "td.cell#total[align]"
+type cssCompound struct {
+	combinator byte // ' ' for a descendant, '>' for a child, 0 for the first part
+	tag        string
+	id         string
+	classes    []string
+	attrs      []htmlAttr // An empty Value only requires the attribute to be present
+	hasValue   []bool
+}
+
+// cssRule is a style rule that can be inlined
+type cssRule struct {
+	selector     cssSelector
+	declarations []cssDeclaration
+	order        int // Position in the stylesheets, the tie-breaker for specificity
+}
+
+// uninlinableElements are never given a style attribute
+var uninlinableElements = map[string]bool{
+	"head": true, "title": true, "meta": true, "link": true, "style": true,
+	"script": true, "base": true, "html": true,
+}
+
+// stripCSSComments removes /* ... */ comments
+func stripCSSComments(css string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			b.WriteString(css)
+			return b.String()
+		}
+		b.WriteString(css[:start])
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			return b.String()
+		}
+		css = css[start+2+end+2:]
+	}
+}
+
+// parseStylesheet returns the rules of a stylesheet that can be inlined.
+// At-rules such as @media and selectors with pseudo-classes or sibling
+// combinators are skipped: they only work from a <style> block.
+func parseStylesheet(css string, order *int) []cssRule {
+	css = stripCSSComments(css)
+	var rules []cssRule
+
+	for i := 0; i < len(css); {
+		for i < len(css) && isSpace(css[i]) {
+			i++
+		}
+		if i >= len(css) {
+			break
+		}
+
+		if css[i] == '@' {
+			i = skipAtRule(css, i)
+			continue
+		}
+
+		open := strings.IndexByte(css[i:], '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(css[i+open:], '}')
+		if end < 0 {
+			end = len(css) - i - open
+		}
+		selectors := css[i : i+open]
+		declarations := parseDeclarations(css[i+open+1 : i+open+end])
+		i += open + end + 1
+
+		for _, text := range splitCSS(selectors, ',') {
+			selector, ok := parseSelector(strings.TrimSpace(text))
+			if !ok || len(declarations) == 0 {
+				continue
+			}
+			rules = append(rules, cssRule{selector: selector, declarations: declarations, order: *order})
+			*order++
+		}
+	}
+
+	return rules
+}
+
+// skipAtRule returns the position after the at-rule starting at i, which
+// ends either with a semicolon or with its block
+func skipAtRule(css string, i int) int {
+	depth := 0
+	for ; i < len(css); i++ {
+		switch css[i] {
+		case ';':
+			if depth == 0 {
+				return i + 1
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth <= 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// parseDeclarations parses the body of a rule or a style attribute
+func parseDeclarations(body string) []cssDeclaration {
+	var declarations []cssDeclaration
+	for _, text := range splitCSS(body, ';') {
+		property, value, found := strings.Cut(text, ":")
+		property = strings.ToLower(strings.TrimSpace(property))
+		value = strings.TrimSpace(value)
+		if !found || property == "" || value == "" {
+			continue
+		}
+
+		declaration := cssDeclaration{Property: property, Value: value}
+		if bang := strings.LastIndexByte(value, '!'); bang >= 0 && strings.EqualFold(strings.TrimSpace(value[bang+1:]), "important") {
+			declaration.Value = strings.TrimSpace(value[:bang])
+			declaration.Important = true
+		}
+		declarations = append(declarations, declaration)
+	}
+	return declarations
+}
+
+// splitCSS splits on sep outside quotes, parentheses and brackets, so that
+// url(data:image/png;base64,...) and [title="a,b"] stay whole
+func splitCSS(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	var quote byte
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseSelector parses a selector made of type, class, ID and attribute
+// selectors joined by descendant or child combinators
+func parseSelector(text string) (cssSelector, bool) {
+	var selector cssSelector
+	if text == "" {
+		return selector, false
+	}
+
+	var combinator byte
+	for i := 0; i < len(text); {
+		// Combinators between compound selectors
+		sawSpace := false
+		for i < len(text) && isSpace(text[i]) {
+			sawSpace = true
+			i++
+		}
+		if i < len(text) && text[i] == '>' {
+			combinator = '>'
+			i++
+			for i < len(text) && isSpace(text[i]) {
+				i++
+			}
+		} else if sawSpace && len(selector.parts) > 0 {
+			combinator = ' '
+		}
+		if i >= len(text) {
+			return selector, false
+		}
+
+		compound, n, ok := parseCompound(text[i:])
+		if !ok {
+			return selector, false
+		}
+		compound.combinator = combinator
+		if len(selector.parts) == 0 {
+			compound.combinator = 0
+		}
+		selector.parts = append(selector.parts, compound)
+
+		if compound.id != "" {
+			selector.specificity[0]++
+		}
+		selector.specificity[1] += len(compound.classes) + len(compound.attrs)
+		if compound.tag != "" && compound.tag != "*" {
+			selector.specificity[2]++
+		}
+
+		i += n
+		combinator = 0
+	}
+
+	return selector, len(selector.parts) > 0
+}
+
+// parseCompound parses a compound selector at the start of text and returns
+// the number of bytes consumed. It fails on anything it cannot match
+// statically, such as :hover or a sibling combinator.
+func parseCompound(text string) (cssCompound, int, bool) {
+	var compound cssCompound
+	i := 0
+
+	readName := func() string {
+		start := i
+		for i < len(text) && isCSSNameChar(text[i]) {
+			i++
+		}
+		return text[start:i]
+	}
+
+	if i < len(text) && text[i] == '*' {
+		compound.tag = "*"
+		i++
+	} else {
+		compound.tag = strings.ToLower(readName())
+	}
+
+	for i < len(text) && !isSpace(text[i]) && text[i] != '>' {
+		switch text[i] {
+		case '.':
+			i++
+			name := readName()
+			if name == "" {
+				return compound, 0, false
+			}
+			compound.classes = append(compound.classes, name)
+		case '#':
+			i++
+			name := readName()
+			if name == "" {
+				return compound, 0, false
+			}
+			compound.id = name
+		case '[':
+			end := strings.IndexByte(text[i:], ']')
+			if end < 0 {
+				return compound, 0, false
+			}
+			name, value, hasValue := strings.Cut(text[i+1:i+end], "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" || strings.ContainsAny(name, "~|^$*") {
+				return compound, 0, false
+			}
+			value = strings.Trim(strings.TrimSpace(value), `"'`)
+			compound.attrs = append(compound.attrs, htmlAttr{Name: name, Value: value})
+			compound.hasValue = append(compound.hasValue, hasValue)
+			i += end + 1
+		default:
+			return compound, 0, false
+		}
+	}
+
+	if compound.tag == "" && compound.id == "" && len(compound.classes) == 0 && len(compound.attrs) == 0 {
+		return compound, 0, false
+	}
+	return compound, i, true
+}
+
+// isCSSNameChar reports whether c can appear in a tag, class or ID name in a
+// selector
+func isCSSNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c >= 0x80
+}
+
+// cssElement is an element of the rendered document, linked to its parent
+// so that descendant and child selectors can be matched
+type cssElement struct {
+	tag     string
+	id      string
+	classes []string
+	token   *htmlToken
+	parent  *cssElement
+}
+
+// matches reports whether the compound selector matches the element
+func (c *cssCompound) matches(e *cssElement) bool {
+	if c.tag != "" && c.tag != "*" && c.tag != e.tag {
+		return false
+	}
+	if c.id != "" && c.id != e.id {
+		return false
+	}
+	for _, class := range c.classes {
+		found := false
+		for _, have := range e.classes {
+			if have == class {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for i, attr := range c.attrs {
+		value, ok := e.token.attr(attr.Name)
+		if !ok || (c.hasValue[i] && html.UnescapeString(value) != attr.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the selector matches the element, trying every
+// ancestor for descendant combinators
+func (s *cssSelector) matches(e *cssElement) bool {
+	return s.matchesFrom(len(s.parts)-1, e)
+}
+
+func (s *cssSelector) matchesFrom(index int, e *cssElement) bool {
+	part := &s.parts[index]
+	if !part.matches(e) {
+		return false
+	}
+	if index == 0 {
+		return true
+	}
+
+	if part.combinator == '>' {
+		return e.parent != nil && s.matchesFrom(index-1, e.parent)
+	}
+	for ancestor := e.parent; ancestor != nil; ancestor = ancestor.parent {
+		if s.matchesFrom(index-1, ancestor) {
+			return true
+		}
+	}
+	return false
+}
+
+// InlineCSS copies the rules of the document's <style> blocks into the style
+// attributes of the elements they match, so that clients which strip or
+// ignore <style> still show the intended styling. The cascade is respected:
+// more specific selectors win, later rules win ties, existing style
+// attributes win over the stylesheet, and !important wins over both. The
+// <style> blocks are kept for media queries and pseudo-classes such as
+// :hover, which cannot be inlined.
+func InlineCSS(htmlContent string) string {
+	tokens := tokenizeHTML(htmlContent)
+
+	// Collect the stylesheets
+	var rules []cssRule
+	order := 0
+	inStyle := false
+	for _, token := range tokens {
+		switch {
+		case token.Type == startTagToken && token.Data == "style":
+			media, _ := token.attr("media")
+			inStyle = media == "" || strings.EqualFold(strings.TrimSpace(media), "all") || strings.EqualFold(strings.TrimSpace(media), "screen")
+		case token.Type == endTagToken && token.Data == "style":
+			inStyle = false
+		case token.Type == textToken && inStyle:
+			rules = append(rules, parseStylesheet(token.Data, &order)...)
+		}
+	}
+	if len(rules) == 0 {
+		return htmlContent
+	}
+
+	// More specific rules, then later ones, are applied last
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, b := rules[i].selector.specificity, rules[j].selector.specificity
+		if a != b {
+			return a[0] < b[0] || a[0] == b[0] && (a[1] < b[1] || a[1] == b[1] && a[2] < b[2])
+		}
+		return rules[i].order < rules[j].order
+	})
+
+	var out strings.Builder
+	var open []*cssElement
+	for i := range tokens {
+		token := &tokens[i]
+		switch token.Type {
+		case endTagToken:
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j].tag == token.Data {
+					open = open[:j]
+					break
+				}
+			}
+			out.WriteString(token.Raw)
+			continue
+		case startTagToken, selfClosingTagToken:
+		default:
+			out.WriteString(token.Raw)
+			continue
+		}
+
+		element := &cssElement{tag: token.Data, token: token}
+		if len(open) > 0 {
+			element.parent = open[len(open)-1]
+		}
+		if id, ok := token.attr("id"); ok {
+			element.id = html.UnescapeString(id)
+		}
+		if class, ok := token.attr("class"); ok {
+			element.classes = strings.Fields(html.UnescapeString(class))
+		}
+		if token.Type == startTagToken {
+			open = append(open, element)
+		}
+
+		if uninlinableElements[token.Data] {
+			out.WriteString(token.Raw)
+			continue
+		}
+		if style, changed := cascade(element, rules); changed {
+			setAttr(token, "style", style)
+			out.WriteString(renderTag(*token))
+		} else {
+			out.WriteString(token.Raw)
+		}
+	}
+
+	return out.String()
+}
+
+// cascade computes the style attribute of an element from the matching rules
+// and the element's own style attribute
+func cascade(element *cssElement, rules []cssRule) (string, bool) {
+	var matched []cssDeclaration
+	for i := range rules {
+		if rules[i].selector.matches(element) {
+			matched = append(matched, rules[i].declarations...)
+		}
+	}
+	if len(matched) == 0 {
+		return "", false
+	}
+
+	existing, _ := element.token.attr("style")
+	inline := parseDeclarations(html.UnescapeString(existing))
+
+	// Apply in increasing priority, so a later declaration overrides an
+	// earlier one: stylesheet, style attribute, then the !important ones
+	var properties []cssDeclaration
+	apply := func(declarations []cssDeclaration, important bool) {
+		for _, d := range declarations {
+			if d.Important != important {
+				continue
+			}
+			for i := range properties {
+				if properties[i].Property == d.Property {
+					properties = append(properties[:i], properties[i+1:]...)
+					break
+				}
+			}
+			properties = append(properties, d)
+		}
+	}
+	apply(matched, false)
+	apply(inline, false)
+	apply(matched, true)
+	apply(inline, true)
+
+	parts := make([]string, len(properties))
+	for i, d := range properties {
+		parts[i] = d.Property + ": " + d.Value
+		if d.Important {
+			parts[i] += " !important"
+		}
+	}
+	return strings.Join(parts, "; ") + ";", true
+}
+
+// setAttr sets an attribute of a tag, adding it if it is missing
+func setAttr(token *htmlToken, name, value string) {
+	for i := range token.Attrs {
+		if token.Attrs[i].Name == name {
+			token.Attrs[i].Value = value
+			return
+		}
+	}
+	token.Attrs = append(token.Attrs, htmlAttr{Name: name, Value: value})
+}
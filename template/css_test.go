@@ -0,0 +1,190 @@
+package template
+
+import (
+	"html"
+	"strings"
+	"testing"
+)
+
+// styleOf returns the style attribute of the element with id "t"
+func styleOf(t *testing.T, document string) string {
+	t.Helper()
+	for _, token := range tokenizeHTML(document) {
+		if id, _ := token.attr("id"); id == "t" {
+			style, _ := token.attr("style")
+			return html.UnescapeString(style)
+		}
+	}
+	t.Fatalf("no element with id t in %s", document)
+	return ""
+}
+
+func TestInlineCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		css  string
+		body string
+		want string
+	}{
+		{
+			name: "type selector",
+			css:  `p { color: red }`,
+			body: `<p id="t">x</p>`,
+			want: "color: red;",
+		},
+		{
+			name: "class beats type",
+			css:  `.c { color: blue } p { color: red }`,
+			body: `<p id="t" class="c">x</p>`,
+			want: "color: blue;",
+		},
+		{
+			name: "id beats class",
+			css:  `#t { color: green } p.c.d { color: blue }`,
+			body: `<p id="t" class="c d">x</p>`,
+			want: "color: green;",
+		},
+		{
+			name: "attribute counts as a class",
+			css:  `p[align] { color: blue } div p { color: red }`,
+			body: `<div><p id="t" align="left">x</p></div>`,
+			want: "color: blue;",
+		},
+		{
+			name: "later rule wins a tie",
+			css:  `.a { color: red } .b { color: blue }`,
+			body: `<p id="t" class="b a">x</p>`,
+			want: "color: blue;",
+		},
+		{
+			name: "declarations are merged",
+			css:  `p { color: red; margin: 0 } .c { color: blue }`,
+			body: `<p id="t" class="c">x</p>`,
+			want: "margin: 0; color: blue;",
+		},
+		{
+			name: "style attribute beats the stylesheet",
+			css:  `#t { color: red; margin: 0 }`,
+			body: `<p id="t" style="color: blue">x</p>`,
+			want: "margin: 0; color: blue;",
+		},
+		{
+			name: "important beats the style attribute",
+			css:  `p { color: red !important }`,
+			body: `<p id="t" style="color: blue">x</p>`,
+			want: "color: red !important;",
+		},
+		{
+			name: "important beats a more specific rule",
+			css:  `p { color: red !important } #t { color: blue }`,
+			body: `<p id="t">x</p>`,
+			want: "color: red !important;",
+		},
+		{
+			name: "important style attribute beats important rule",
+			css:  `#t { color: red !important }`,
+			body: `<p id="t" style="color: blue !important">x</p>`,
+			want: "color: blue !important;",
+		},
+		{
+			name: "specificity orders important rules",
+			css:  `#t { color: blue !important } p { color: red !important }`,
+			body: `<p id="t">x</p>`,
+			want: "color: blue !important;",
+		},
+		{
+			name: "descendant combinator",
+			css:  `.box span { color: red }`,
+			body: `<div class="box"><p><span id="t">x</span></p></div>`,
+			want: "color: red;",
+		},
+		{
+			name: "child combinator needs the parent",
+			css:  `.box > span { color: red } span { margin: 0 }`,
+			body: `<div class="box"><p><span id="t">x</span></p></div>`,
+			want: "margin: 0;",
+		},
+		{
+			name: "closed element is not an ancestor",
+			css:  `.box span { color: red } span { margin: 0 }`,
+			body: `<div class="box"></div><span id="t">x</span>`,
+			want: "margin: 0;",
+		},
+		{
+			name: "attribute value",
+			css:  `td[align="right"] { color: red } td[align="left"] { color: blue }`,
+			body: `<table><tr><td id="t" align="right">x</td></tr></table>`,
+			want: "color: red;",
+		},
+		{
+			name: "selector list",
+			css:  `h1, .c { color: red }`,
+			body: `<p id="t" class="c">x</p>`,
+			want: "color: red;",
+		},
+		{
+			name: "pseudo-class is not inlined",
+			css:  `a:hover { color: red } a { color: blue }`,
+			body: `<a id="t" href="https://example.com">x</a>`,
+			want: "color: blue;",
+		},
+		{
+			name: "media query is not inlined",
+			css:  `@media (max-width: 600px) { p { color: red } } p { margin: 0 }`,
+			body: `<p id="t">x</p>`,
+			want: "margin: 0;",
+		},
+		{
+			name: "comments are ignored",
+			css:  `/* p { color: red } */ p { margin: 0 /* none */ }`,
+			body: `<p id="t">x</p>`,
+			want: "margin: 0;",
+		},
+		{
+			name: "unmatched element is left alone",
+			css:  `h1 { color: red }`,
+			body: `<p id="t" style="margin: 0">x</p>`,
+			want: "margin: 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document := "<html><head><style>" + tt.css + "</style></head><body>" + tt.body + "</body></html>"
+			if got := styleOf(t, InlineCSS(document)); got != tt.want {
+				t.Errorf("style = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInlineCSSStyleBlocks(t *testing.T) {
+	document := `<html><head>` +
+		`<style>p { color: red } a:hover { color: blue }</style>` +
+		`<style media="print">p { margin: 0 }</style>` +
+		`<style media="screen">p { padding: 0 }</style>` +
+		`</head><body><p id="t">x</p></body></html>`
+	inlined := InlineCSS(document)
+
+	if got, want := styleOf(t, inlined), "color: red; padding: 0;"; got != want {
+		t.Errorf("style = %q, want %q", got, want)
+	}
+	// The stylesheets stay for the rules that cannot be inlined
+	for _, block := range []string{
+		`<style>p { color: red } a:hover { color: blue }</style>`,
+		`<style media="print">p { margin: 0 }</style>`,
+	} {
+		if !strings.Contains(inlined, block) {
+			t.Errorf("%s was removed from %s", block, inlined)
+		}
+	}
+	if strings.Contains(inlined, `<style style=`) || strings.Contains(inlined, `<head style=`) {
+		t.Errorf("style attribute added to the head: %s", inlined)
+	}
+}
+
+func TestInlineCSSWithoutStylesheet(t *testing.T) {
+	document := `<p class="c">x</p>`
+	if got := InlineCSS(document); got != document {
+		t.Errorf("InlineCSS changed a document without a stylesheet: %s", got)
+	}
+}
@@ -0,0 +1,203 @@
+package template
+
+import (
+	"sort"
+	"text/template/parse"
+)
+
+// Field is a data field referenced by a template. Optional fields are only
+// used in conditions such as {{if .X}} or with a fallback such as
+// {{.X | default "y"}}, so the template renders sensibly without them.
+type Field struct {
+	Name     string
+	Optional bool
+}
+
+// fieldWalker collects the fields of the root data that a template and the
+// templates it includes refer to
+type fieldWalker struct {
+	lookup   func(name string) *parse.Tree
+	fields   map[string]bool // Field name to whether every reference is optional
+	visiting map[string]bool // Templates being walked, to stop recursion
+	guarded  map[string]int  // Fields tested by an enclosing {{if}} or {{with}}
+}
+
+// collectFields returns the fields referenced from the tree of the named
+// template, sorted by name
+func collectFields(name string, lookup func(name string) *parse.Tree) []Field {
+	w := &fieldWalker{
+		lookup:   lookup,
+		fields:   make(map[string]bool),
+		visiting: make(map[string]bool),
+		guarded:  make(map[string]int),
+	}
+	w.template(name)
+	return w.list()
+}
+
+// list returns the collected fields sorted by name
+func (w *fieldWalker) list() []Field {
+	fields := make([]Field, 0, len(w.fields))
+	for name, optional := range w.fields {
+		fields = append(fields, Field{Name: name, Optional: optional})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// add records a reference; a field is required if any reference outside a
+// test of it requires it
+func (w *fieldWalker) add(name string, optional bool) {
+	if w.guarded[name] > 0 {
+		optional = true
+	}
+	if existing, ok := w.fields[name]; ok {
+		optional = optional && existing
+	}
+	w.fields[name] = optional
+}
+
+// template walks the named template with the root data as its dot
+func (w *fieldWalker) template(name string) {
+	tree := w.lookup(name)
+	if tree == nil || tree.Root == nil || w.visiting[name] {
+		return
+	}
+
+	w.visiting[name] = true
+	w.node(tree.Root, true, true)
+	delete(w.visiting, name)
+}
+
+// node walks a node. dotIsRoot and dollarIsRoot tell whether "." and "$"
+// still refer to the root data; inside {{with}} and {{range}} the dot moves.
+func (w *fieldWalker) node(node parse.Node, dotIsRoot, dollarIsRoot bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			w.node(child, dotIsRoot, dollarIsRoot)
+		}
+
+	case *parse.ActionNode:
+		w.pipe(n.Pipe, false, dotIsRoot, dollarIsRoot)
+
+	case *parse.IfNode:
+		// A condition tests whether the field is set, so {{if .X}}{{.X}}{{end}}
+		// renders without it
+		w.pipe(n.Pipe, true, dotIsRoot, dollarIsRoot)
+		release := w.guard(n.Pipe, dotIsRoot, dollarIsRoot)
+		w.node(n.List, dotIsRoot, dollarIsRoot)
+		release()
+		w.node(n.ElseList, dotIsRoot, dollarIsRoot)
+
+	case *parse.WithNode:
+		w.pipe(n.Pipe, true, dotIsRoot, dollarIsRoot)
+		release := w.guard(n.Pipe, dotIsRoot, dollarIsRoot)
+		w.node(n.List, false, dollarIsRoot)
+		release()
+		w.node(n.ElseList, dotIsRoot, dollarIsRoot)
+
+	case *parse.RangeNode:
+		w.pipe(n.Pipe, true, dotIsRoot, dollarIsRoot)
+		w.node(n.List, false, dollarIsRoot)
+		w.node(n.ElseList, dotIsRoot, dollarIsRoot)
+
+	case *parse.TemplateNode:
+		if n.Pipe == nil {
+			return
+		}
+		w.pipe(n.Pipe, false, dotIsRoot, dollarIsRoot)
+
+		// Only a template called with the root data as its dot reads root fields
+		if dotIsRoot && len(n.Pipe.Decl) == 0 && len(n.Pipe.Cmds) == 1 && len(n.Pipe.Cmds[0].Args) == 1 {
+			if _, ok := n.Pipe.Cmds[0].Args[0].(*parse.DotNode); ok {
+				w.template(n.Name)
+			}
+		}
+	}
+}
+
+// guard marks the fields tested by a condition as optional until the
+// returned function is called
+func (w *fieldWalker) guard(pipe *parse.PipeNode, dotIsRoot, dollarIsRoot bool) func() {
+	tested := &fieldWalker{fields: make(map[string]bool), guarded: make(map[string]int)}
+	tested.pipe(pipe, true, dotIsRoot, dollarIsRoot)
+	for name := range tested.fields {
+		w.guarded[name]++
+	}
+	return func() {
+		for name := range tested.fields {
+			w.guarded[name]--
+		}
+	}
+}
+
+// pipe walks the commands of a pipeline. Fields piped into or passed to
+// default are optional.
+func (w *fieldWalker) pipe(pipe *parse.PipeNode, optional, dotIsRoot, dollarIsRoot bool) {
+	if pipe == nil {
+		return
+	}
+
+	for _, cmd := range pipe.Cmds {
+		if isIdentifier(cmd, "default") {
+			optional = true
+		}
+	}
+
+	for _, cmd := range pipe.Cmds {
+		// {{index . "Referrer Name"}} reads a key that is not an identifier
+		if isIdentifier(cmd, "index") && len(cmd.Args) >= 3 && dotIsRoot {
+			if _, ok := cmd.Args[1].(*parse.DotNode); ok {
+				if key, ok := cmd.Args[2].(*parse.StringNode); ok {
+					w.add(key.Text, optional)
+				}
+			}
+		}
+
+		for _, arg := range cmd.Args {
+			w.arg(arg, optional, dotIsRoot, dollarIsRoot)
+		}
+	}
+}
+
+// arg walks a single command argument
+func (w *fieldWalker) arg(arg parse.Node, optional, dotIsRoot, dollarIsRoot bool) {
+	switch a := arg.(type) {
+	case *parse.FieldNode:
+		if dotIsRoot && len(a.Ident) > 0 {
+			w.add(a.Ident[0], optional)
+		}
+	case *parse.VariableNode:
+		if dollarIsRoot && len(a.Ident) > 1 && a.Ident[0] == "$" {
+			w.add(a.Ident[1], optional)
+		}
+	case *parse.ChainNode:
+		w.arg(a.Node, optional, dotIsRoot, dollarIsRoot)
+	case *parse.PipeNode:
+		w.pipe(a, optional, dotIsRoot, dollarIsRoot)
+	}
+}
+
+// isIdentifier reports whether a command calls the named function
+func isIdentifier(cmd *parse.CommandNode, name string) bool {
+	if len(cmd.Args) == 0 {
+		return false
+	}
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	return ok && ident.Ident == name
+}
+
+// mergeFields combines field lists; a field is required if any list requires it
+func mergeFields(lists ...[]Field) []Field {
+	w := &fieldWalker{fields: make(map[string]bool), guarded: make(map[string]int)}
+	for _, list := range lists {
+		for _, f := range list {
+			w.add(f.Name, f.Optional)
+		}
+	}
+	return w.list()
+}
@@ -0,0 +1,91 @@
+package template
+
+import (
+	"fmt"
+	"testing"
+	texttemplate "text/template"
+	"text/template/parse"
+)
+
+// fieldsOf parses src with the template functions and returns its fields
+// as "Name" or "Name?" for optional ones
+func fieldsOf(t *testing.T, src string) string {
+	t.Helper()
+	tmpl, err := texttemplate.New("email").Funcs(funcs).Parse(src)
+	if err != nil {
+		t.Fatalf("parse %q: %v", src, err)
+	}
+	fields := collectFields("email", func(name string) *parse.Tree {
+		if t := tmpl.Lookup(name); t != nil {
+			return t.Tree
+		}
+		return nil
+	})
+
+	var list []string
+	for _, f := range fields {
+		if f.Optional {
+			list = append(list, f.Name+"?")
+		} else {
+			list = append(list, f.Name)
+		}
+	}
+	return fmt.Sprint(list)
+}
+
+func TestCollectFields(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"field", `{{.Name}} at {{.Company}}`, "[Company Name]"},
+		{"chained field", `{{.Company.Name}}`, "[Company]"},
+		{"dollar", `{{$.Name}}`, "[Name]"},
+		{"index with a key", `{{index . "Referrer Name"}}`, "[Referrer Name]"},
+		{"function argument", `{{title .Role}}`, "[Role]"},
+		{"variable", `{{$n := .Name}}{{$n}}`, "[Name]"},
+
+		{"if guards its body", `{{if .Cc}}cc {{.Cc}}{{end}}`, "[Cc?]"},
+		{"if does not guard else", `{{if .Cc}}x{{else}}{{.Cc}}{{end}}`, "[Cc]"},
+		{"use outside if", `{{if .Cc}}x{{end}}{{.Cc}}`, "[Cc]"},
+		{"and guards both", `{{if and .A .B}}{{.A}}{{.B}}{{end}}`, "[A? B?]"},
+		{"nested if", `{{if .A}}{{if .B}}{{.A}}{{.B}}{{.C}}{{end}}{{end}}`, "[A? B? C]"},
+		{"default piped", `{{.Referrer | default "a friend"}}`, "[Referrer?]"},
+		{"default called", `{{default "a friend" .Referrer}}`, "[Referrer?]"},
+
+		{"with moves dot", `{{with .Company}}{{.Name}}{{end}}`, "[Company?]"},
+		{"with keeps dollar", `{{with .Company}}{{$.Role}}{{end}}`, "[Company? Role]"},
+		{"with else keeps dot", `{{with .Company}}x{{else}}{{.Fallback}}{{end}}`, "[Company? Fallback]"},
+		{"with variable", `{{with $c := .Company}}{{$c}}{{end}}`, "[Company?]"},
+		{"range moves dot", `{{range .Items}}{{.Title}}{{end}}`, "[Items?]"},
+		{"range keeps dollar", `{{range .Items}}{{$.Sender}}{{end}}`, "[Items? Sender]"},
+		{"range else keeps dot", `{{range .Items}}x{{else}}{{.Empty}}{{end}}`, "[Empty Items?]"},
+
+		{"block", `{{block "greeting" .}}Hi {{.Name}}{{end}}`, "[Name]"},
+		{"template with root dot", `{{template "sig" .}}{{define "sig"}}{{.Phone}}{{end}}`, "[Phone]"},
+		{"template with another dot", `{{template "sig" .Contact}}{{define "sig"}}{{.Phone}}{{end}}`, "[Contact]"},
+		{"template inside with", `{{with .Contact}}{{template "sig" .}}{{end}}{{define "sig"}}{{.Phone}}{{end}}`, "[Contact?]"},
+		{"template inside if", `{{if .Phone}}{{template "sig" .}}{{end}}{{define "sig"}}{{.Phone}}{{end}}`, "[Phone?]"},
+		{"recursive template", `{{define "r"}}{{.X}}{{template "r" .}}{{end}}{{template "r" .}}`, "[X]"},
+		{"missing template", `{{define "a"}}{{.X}}{{end}}{{.Y}}`, "[Y]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fieldsOf(t, tt.src); got != tt.want {
+				t.Errorf("fields of %s = %s, want %s", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeFields(t *testing.T) {
+	merged := mergeFields(
+		[]Field{{Name: "A", Optional: true}, {Name: "B", Optional: true}},
+		[]Field{{Name: "B"}, {Name: "C", Optional: true}},
+	)
+	got := fmt.Sprint(merged)
+	if want := "[{A true} {B false} {C true}]"; got != want {
+		t.Errorf("mergeFields = %s, want %s", got, want)
+	}
+}
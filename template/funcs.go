@@ -0,0 +1,200 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// funcs is the function library available to every HTML and plain-text
+// template, e.g. {{firstName .RecipientName}} or {{.Role | default "the role"}}
+var funcs = map[string]interface{}{
+	"title":      titleCase,
+	"firstName":  firstName,
+	"formatDate": formatDate,
+	"default":    defaultValue,
+	"plural":     plural,
+	"dict":       dict,
+}
+
+// honorifics are skipped by firstName
+var honorifics = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "miss": true, "mx": true,
+	"dr": true, "prof": true, "sir": true, "madam": true,
+}
+
+// titleCase capitalises the first letter of every word and lower-cases the
+// rest, so "jOHN o'neil-smith" becomes "John O'neil-Smith"
+func titleCase(s string) string {
+	var b strings.Builder
+	startOfWord := true
+	for _, r := range s {
+		if startOfWord {
+			b.WriteRune(unicode.ToUpper(r))
+		} else {
+			b.WriteRune(unicode.ToLower(r))
+		}
+		startOfWord = unicode.IsSpace(r) || r == '-'
+	}
+	return b.String()
+}
+
+// firstName returns the first name from a full name, skipping honorifics
+// such as "Dr." and title-casing the result
+func firstName(fullName string) string {
+	for _, word := range strings.Fields(fullName) {
+		if honorifics[strings.ToLower(strings.TrimSuffix(word, "."))] {
+			continue
+		}
+		return titleCase(strings.Trim(word, ",;"))
+	}
+	return ""
+}
+
+// dateLayouts are the formats accepted for dates given as strings
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02/01/2006",
+}
+
+// formatDate formats a date with a Go layout. The date is the last argument
+// so that it can be piped in, optionally preceded by the IANA timezone to
+// show it in:
+//
+//	{{formatDate "Monday, 2 January" .InterviewDate}}
+//	{{.InterviewDate | formatDate "3:04 PM MST" .Timezone}}
+//
+// Dates can be time.Time values or strings in RFC 3339 or YYYY-MM-DD form.
+func formatDate(layout string, args ...interface{}) (string, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return "", errors.New("formatDate takes a layout, an optional timezone and a date")
+	}
+
+	var t time.Time
+	switch value := args[len(args)-1].(type) {
+	case time.Time:
+		t = value
+	case *time.Time:
+		if value == nil {
+			return "", nil
+		}
+		t = *value
+	case string:
+		if value == "" {
+			return "", nil
+		}
+		parsed, err := parseDate(value)
+		if err != nil {
+			return "", err
+		}
+		t = parsed
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("formatDate cannot format %T", value)
+	}
+
+	if len(args) == 2 {
+		if tz, _ := args[0].(string); tz != "" {
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				return "", fmt.Errorf("formatDate: unknown timezone %q", tz)
+			}
+			t = t.In(loc)
+		}
+	}
+
+	return t.Format(layout), nil
+}
+
+// parseDate parses a date in one of dateLayouts
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("formatDate cannot parse date %q", value)
+}
+
+// defaultValue returns value, or def if value is empty. Empty means nil, a
+// blank string or a zero number, so that missing sheet cells fall back:
+// {{.ReferrerName | default "your team"}}
+func defaultValue(def, value interface{}) interface{} {
+	if isEmpty(value) {
+		return def
+	}
+	return value
+}
+
+// isEmpty reports whether a template value counts as empty
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	if s, ok := value.(string); ok {
+		return strings.TrimSpace(s) == ""
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// plural returns one when count is exactly one and many otherwise:
+// {{.Years}} {{plural .Years "year" "years"}}. Counts may be numbers or
+// numeric strings, as sheet cells often are.
+func plural(count interface{}, one, many string) (string, error) {
+	var n float64
+	switch value := count.(type) {
+	case int:
+		n = float64(value)
+	case int64:
+		n = float64(value)
+	case float64:
+		n = value
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", fmt.Errorf("plural: %q is not a number", value)
+		}
+		n = parsed
+	default:
+		return "", fmt.Errorf("plural: cannot count %T", count)
+	}
+
+	if n == 1 {
+		return one, nil
+	}
+	return many, nil
+}
+
+// dict builds a map from alternating keys and values so that a partial can
+// be given parameters: {{template "signature" dict "LinkColor" "#0553B1"}}
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict takes pairs of keys and values")
+	}
+
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings, got %T", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
@@ -0,0 +1,224 @@
+package template
+
+import (
+	"strings"
+)
+
+// htmlTokenType identifies the kind of an htmlToken
+type htmlTokenType int
+
+const (
+	textToken htmlTokenType = iota
+	startTagToken
+	endTagToken
+	selfClosingTagToken
+	commentToken
+	doctypeToken
+)
+
+// htmlAttr is a single attribute of a tag, kept in source order
+type htmlAttr struct {
+	Name  string
+	Value string
+}
+
+// htmlToken is a piece of an HTML document: a run of text, a tag, a comment
+// or a doctype. Raw holds the exact source of the token.
+type htmlToken struct {
+	Type  htmlTokenType
+	Data  string // Lower-cased tag name, or the text/comment content
+	Attrs []htmlAttr
+	Raw   string
+}
+
+// attr returns the value of the named attribute
+func (t htmlToken) attr(name string) (string, bool) {
+	for _, a := range t.Attrs {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// rawTextElements hold text that must not be parsed as markup
+var rawTextElements = map[string]bool{
+	"script": true,
+	"style":  true,
+}
+
+// voidElements never have a closing tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+// tokenizeHTML splits an HTML document into tokens. It is a small, forgiving
+// tokenizer for email templates rather than a full HTML5 parser.
+func tokenizeHTML(src string) []htmlToken {
+	var tokens []htmlToken
+	i := 0
+
+	for i < len(src) {
+		lt := strings.IndexByte(src[i:], '<')
+		if lt < 0 {
+			tokens = append(tokens, htmlToken{Type: textToken, Data: src[i:], Raw: src[i:]})
+			break
+		}
+		if lt > 0 {
+			tokens = append(tokens, htmlToken{Type: textToken, Data: src[i : i+lt], Raw: src[i : i+lt]})
+			i += lt
+		}
+
+		// Comments
+		if strings.HasPrefix(src[i:], "<!--") {
+			end := strings.Index(src[i+4:], "-->")
+			if end < 0 {
+				tokens = append(tokens, htmlToken{Type: commentToken, Data: src[i+4:], Raw: src[i:]})
+				break
+			}
+			raw := src[i : i+4+end+3]
+			tokens = append(tokens, htmlToken{Type: commentToken, Data: src[i+4 : i+4+end], Raw: raw})
+			i += len(raw)
+			continue
+		}
+
+		// Doctype and other declarations
+		if strings.HasPrefix(src[i:], "<!") {
+			end := strings.IndexByte(src[i:], '>')
+			if end < 0 {
+				end = len(src) - i - 1
+			}
+			raw := src[i : i+end+1]
+			tokens = append(tokens, htmlToken{Type: doctypeToken, Data: raw, Raw: raw})
+			i += len(raw)
+			continue
+		}
+
+		token, n := parseTag(src[i:])
+		if n == 0 {
+			// A lone '<' is just text
+			tokens = append(tokens, htmlToken{Type: textToken, Data: "<", Raw: "<"})
+			i++
+			continue
+		}
+		tokens = append(tokens, token)
+		i += n
+
+		// Everything up to the closing tag of a raw text element is text
+		if token.Type == startTagToken && rawTextElements[token.Data] {
+			closing := strings.Index(strings.ToLower(src[i:]), "</"+token.Data)
+			if closing < 0 {
+				closing = len(src) - i
+			}
+			if closing > 0 {
+				tokens = append(tokens, htmlToken{Type: textToken, Data: src[i : i+closing], Raw: src[i : i+closing]})
+				i += closing
+			}
+		}
+	}
+
+	return tokens
+}
+
+// parseTag parses a start or end tag at the beginning of src and returns it
+// with the number of bytes consumed, or 0 if src does not start with a tag
+func parseTag(src string) (htmlToken, int) {
+	i := 1
+	closing := false
+	if i < len(src) && src[i] == '/' {
+		closing = true
+		i++
+	}
+
+	start := i
+	for i < len(src) && isTagNameChar(src[i]) {
+		i++
+	}
+	if i == start {
+		return htmlToken{}, 0
+	}
+	name := strings.ToLower(src[start:i])
+
+	var attrs []htmlAttr
+	selfClosing := false
+	for i < len(src) {
+		for i < len(src) && isSpace(src[i]) {
+			i++
+		}
+		if i >= len(src) {
+			break
+		}
+		if src[i] == '>' {
+			i++
+			break
+		}
+		if src[i] == '/' {
+			selfClosing = true
+			i++
+			continue
+		}
+
+		// Attribute name
+		nameStart := i
+		for i < len(src) && !isSpace(src[i]) && src[i] != '=' && src[i] != '>' && src[i] != '/' {
+			i++
+		}
+		attr := htmlAttr{Name: strings.ToLower(src[nameStart:i])}
+		for i < len(src) && isSpace(src[i]) {
+			i++
+		}
+
+		// Optional value, quoted or bare
+		if i < len(src) && src[i] == '=' {
+			i++
+			for i < len(src) && isSpace(src[i]) {
+				i++
+			}
+			if i < len(src) && (src[i] == '"' || src[i] == '\'') {
+				quote := src[i]
+				end := strings.IndexByte(src[i+1:], quote)
+				if end < 0 {
+					end = len(src) - i - 1
+				}
+				attr.Value = src[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				valueStart := i
+				for i < len(src) && !isSpace(src[i]) && src[i] != '>' {
+					i++
+				}
+				attr.Value = src[valueStart:i]
+			}
+		}
+		if attr.Name != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	if i > len(src) {
+		i = len(src)
+	}
+
+	token := htmlToken{Data: name, Attrs: attrs, Raw: src[:i]}
+	switch {
+	case closing:
+		token.Type = endTagToken
+	case selfClosing || voidElements[name]:
+		token.Type = selfClosingTagToken
+	default:
+		token.Type = startTagToken
+	}
+
+	return token, i
+}
+
+// isTagNameChar reports whether c can appear in a tag name
+func isTagNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == ':'
+}
+
+// isSpace reports whether c is HTML whitespace
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,155 @@
+package template
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// InlineImage is a local image referenced by a template. The mailer embeds it
+// in the email so that it shows without loading remote content.
+type InlineImage struct {
+	ContentID string // Referenced as "cid:<ContentID>" in the rendered HTML
+	Path      string // Location of the image file on disk
+}
+
+// embedImages rewrites the src of every <img> that points at a local file to
+// a cid: URL and returns the images to embed. Relative paths are resolved
+// against baseDir, the directory of the template.
+func embedImages(htmlContent, baseDir string) (string, []InlineImage, error) {
+	var out strings.Builder
+	var images []InlineImage
+	contentIDs := make(map[string]string) // Path to Content-ID, so repeated images are embedded once
+
+	for _, token := range tokenizeHTML(htmlContent) {
+		if (token.Type != startTagToken && token.Type != selfClosingTagToken) || token.Data != "img" {
+			out.WriteString(token.Raw)
+			continue
+		}
+
+		src, _ := token.attr("src")
+		path, ok, err := localImagePath(html.UnescapeString(src), baseDir)
+		if err != nil {
+			return "", nil, err
+		}
+		if !ok {
+			out.WriteString(token.Raw)
+			continue
+		}
+
+		contentID, seen := contentIDs[path]
+		if !seen {
+			info, err := os.Stat(path)
+			if err != nil {
+				return "", nil, fmt.Errorf("inline image: %w", err)
+			}
+			if !info.Mode().IsRegular() {
+				return "", nil, fmt.Errorf("inline image %s is not a regular file", path)
+			}
+
+			if contentID, err = newContentID(path); err != nil {
+				return "", nil, err
+			}
+			contentIDs[path] = contentID
+			images = append(images, InlineImage{ContentID: contentID, Path: path})
+		}
+
+		for i := range token.Attrs {
+			if token.Attrs[i].Name == "src" {
+				token.Attrs[i].Value = "cid:" + contentID
+			}
+		}
+		out.WriteString(renderTag(token))
+	}
+
+	return out.String(), images, nil
+}
+
+// localImagePath returns the file an image src refers to, or false if src is
+// a remote, data or cid URL. Local images must be inside baseDir, the
+// directory of the template, so that a src filled in from recipient data
+// cannot embed other files such as /etc/passwd or ../../.env.
+func localImagePath(src, baseDir string) (string, bool, error) {
+	src = strings.TrimSpace(src)
+	if src == "" || strings.HasPrefix(src, "//") || strings.HasPrefix(src, "#") {
+		return "", false, nil
+	}
+
+	u, err := url.Parse(src)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "", false, nil
+	}
+
+	path := filepath.FromSlash(u.Path)
+	if filepath.IsAbs(path) || filepath.VolumeName(path) != "" {
+		return "", true, fmt.Errorf("inline image %s: absolute paths are not allowed, use a path relative to the template", src)
+	}
+	path = filepath.Join(baseDir, path)
+	if !withinDir(path, baseDir) {
+		return "", true, fmt.Errorf("inline image %s is outside the template directory", src)
+	}
+
+	// A symlink must not lead out of the directory either
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		resolvedBase, err := filepath.EvalSymlinks(baseDir)
+		if err != nil || !withinDir(resolved, resolvedBase) {
+			return "", true, fmt.Errorf("inline image %s links outside the template directory", src)
+		}
+	}
+
+	return path, true, nil
+}
+
+// withinDir reports whether path is dir or lies below it
+func withinDir(path, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// newContentID returns a globally unique Content-ID for an image file
+func newContentID(path string) (string, error) {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, filepath.Base(path))
+
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("error generating Content-ID for %s: %w", path, err)
+	}
+
+	return fmt.Sprintf("%s.%s@go-mailer", name, hex.EncodeToString(random)), nil
+}
+
+// renderTag writes a start tag back out from its name and attributes
+func renderTag(token htmlToken) string {
+	var b strings.Builder
+	b.WriteString("<" + token.Data)
+	for _, attr := range token.Attrs {
+		b.WriteString(" " + attr.Name + `="` + html.EscapeString(html.UnescapeString(attr.Value)) + `"`)
+	}
+	if token.Type == selfClosingTagToken && strings.HasSuffix(strings.TrimSpace(token.Raw), "/>") {
+		b.WriteString(" /")
+	}
+	b.WriteString(">")
+	return b.String()
+}
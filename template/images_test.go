@@ -0,0 +1,85 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalImagePath(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "tamplets")
+	if err := os.MkdirAll(filepath.Join(dir, "assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "secret.env"), []byte("TOKEN=x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "secret.env"), filepath.Join(dir, "assets", "link.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		src     string
+		want    string // Relative to dir; empty when not local
+		wantErr bool
+	}{
+		{src: "assets/logo.png", want: "assets/logo.png"},
+		{src: "./assets/../logo.png", want: "logo.png"},
+		{src: "assets/my%20logo.png", want: "assets/my logo.png"},
+		{src: "https://example.com/logo.png"},
+		{src: "//cdn.example.com/logo.png"},
+		{src: "data:image/png;base64,AAAA"},
+		{src: "cid:logo"},
+		{src: "/etc/passwd", wantErr: true},
+		{src: "../secret.env", wantErr: true},
+		{src: "../../.env", wantErr: true},
+		{src: "assets/../../secret.env", wantErr: true},
+		{src: "%2e%2e/secret.env", wantErr: true},
+		{src: "assets/link.png", wantErr: true},
+	}
+	for _, tt := range tests {
+		path, ok, err := localImagePath(tt.src, dir)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: accepted as %s", tt.src, path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%s: treated as the local file %s", tt.src, path)
+			}
+			continue
+		}
+		if want := filepath.Join(dir, filepath.FromSlash(tt.want)); !ok || path != want {
+			t.Errorf("%s: got %s, %v, want %s", tt.src, path, ok, want)
+		}
+	}
+}
+
+func TestEmbedImagesRejectsEscapingPaths(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "logo.png"), []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, images, err := embedImages(`<img src="logo.png"><img src="logo.png" alt="again">`, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || strings.Count(rendered, "cid:"+images[0].ContentID) != 2 {
+		t.Errorf("got %d images, rendered %s", len(images), rendered)
+	}
+
+	for _, src := range []string{"/etc/passwd", "../../.env"} {
+		if _, _, err := embedImages(`<img src="`+src+`">`, dir); err == nil {
+			t.Errorf("%s was embedded", src)
+		}
+	}
+}
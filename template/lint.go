@@ -0,0 +1,227 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LintSeverity tells whether a lint issue breaks the email or only looks wrong
+type LintSeverity string
+
+const (
+	LintError   LintSeverity = "error"
+	LintWarning LintSeverity = "warning"
+)
+
+// LintIssue is a problem found in a template
+type LintIssue struct {
+	Severity LintSeverity
+	Message  string
+}
+
+// LintReport lists the problems found in a template and the web links it
+// contains, which the caller can check online
+type LintReport struct {
+	Path   string
+	Issues []LintIssue
+	Links  []string
+}
+
+// HasErrors reports whether any issue is an error
+func (r *LintReport) HasErrors() bool {
+	for _, issue := range r.Issues {
+		if issue.Severity == LintError {
+			return true
+		}
+	}
+	return false
+}
+
+// addf records an issue
+func (r *LintReport) addf(severity LintSeverity, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, LintIssue{Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// optionalEndTags may be left open; the browser closes them implicitly
+var optionalEndTags = map[string]bool{
+	"p": true, "li": true, "dt": true, "dd": true, "option": true,
+	"tr": true, "td": true, "th": true, "thead": true, "tbody": true, "tfoot": true,
+}
+
+// Lint checks the template at path against sample data. It reports fields
+// the data does not provide, then renders the HTML with placeholders for
+// them and checks that the markup is balanced and that links and images
+// point somewhere.
+func (r *Registry) Lint(path string, data TemplateData) *LintReport {
+	report := &LintReport{Path: path}
+
+	cached, err := r.get(path)
+	if err != nil {
+		report.addf(LintError, "%v", err)
+		return report
+	}
+
+	// Fill in missing fields so the rest of the template can still be checked
+	data = Merge(data)
+	for _, f := range cached.fields {
+		if _, ok := data[f.Name]; ok || f.Optional {
+			continue
+		}
+		report.addf(LintError, "unknown field .%s: not in the data", f.Name)
+		data[f.Name] = "[" + f.Name + "]"
+	}
+	data = cached.withOptionalFields(data)
+
+	var rendered bytes.Buffer
+	if err := cached.html.Execute(&rendered, data); err != nil {
+		report.addf(LintError, "error rendering: %v", err)
+		return report
+	}
+	if cached.subject != nil {
+		if err := cached.subject.Execute(&bytes.Buffer{}, data); err != nil {
+			report.addf(LintError, "error rendering subject: %v", err)
+		}
+	} else {
+		report.addf(LintWarning, "no subject defined; every row must give one")
+	}
+	if cached.text != nil {
+		if err := cached.text.Execute(&bytes.Buffer{}, data); err != nil {
+			report.addf(LintError, "error rendering %s: %v", filepath.Base(TextTemplatePath(path)), err)
+		}
+	}
+	if strings.Contains(rendered.String(), "&lt;no value&gt;") {
+		report.addf(LintWarning, "rendered output contains <no value>; a nested field is missing")
+	}
+
+	lintMarkup(report, rendered.String())
+	lintLinks(report, rendered.String(), filepath.Dir(path))
+
+	return report
+}
+
+// lintMarkup reports elements that are closed without being opened or that
+// are never closed
+func lintMarkup(report *LintReport, rendered string) {
+	var open []string
+
+	for _, token := range tokenizeHTML(rendered) {
+		switch token.Type {
+		case startTagToken:
+			if !voidElements[token.Data] {
+				open = append(open, token.Data)
+			}
+
+		case endTagToken:
+			if voidElements[token.Data] {
+				continue
+			}
+
+			// Find the matching start tag. Elements left open inside it are
+			// reported, except those whose end tag is optional.
+			match := -1
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == token.Data {
+					match = i
+					break
+				}
+			}
+			if match < 0 {
+				report.addf(LintError, "unbalanced HTML: </%s> has no opening tag", token.Data)
+				continue
+			}
+			for _, name := range open[match+1:] {
+				if !optionalEndTags[name] {
+					report.addf(LintError, "unbalanced HTML: <%s> is not closed before </%s>", name, token.Data)
+				}
+			}
+			open = open[:match]
+		}
+	}
+
+	for _, name := range open {
+		if !optionalEndTags[name] {
+			report.addf(LintError, "unbalanced HTML: <%s> is never closed", name)
+		}
+	}
+}
+
+// lintLinks checks the targets of links and images
+func lintLinks(report *LintReport, rendered, baseDir string) {
+	for _, token := range tokenizeHTML(rendered) {
+		if token.Type != startTagToken && token.Type != selfClosingTagToken {
+			continue
+		}
+
+		switch token.Data {
+		case "a":
+			href, ok := token.attr("href")
+			if !ok {
+				continue
+			}
+			lintHref(report, strings.TrimSpace(href))
+
+		case "img":
+			src, _ := token.attr("src")
+			if strings.TrimSpace(src) == "" {
+				report.addf(LintError, "image without a src")
+				continue
+			}
+			if path, ok, err := localImagePath(html.UnescapeString(src), baseDir); err != nil {
+				report.addf(LintError, "%v", err)
+			} else if ok {
+				if _, err := os.Stat(path); err != nil {
+					report.addf(LintError, "broken image %s: %v", src, err)
+				}
+			} else if u, err := url.Parse(src); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+				report.Links = append(report.Links, src)
+			}
+		}
+	}
+}
+
+// lintHref checks a single link target
+func lintHref(report *LintReport, href string) {
+	if href == "" || href == "#" {
+		report.addf(LintWarning, "link with an empty target")
+		return
+	}
+
+	u, err := url.Parse(href)
+	if err != nil {
+		report.addf(LintError, "broken link %s: %v", href, err)
+		return
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			report.addf(LintError, "broken link %s: no host", href)
+			return
+		}
+		report.Links = append(report.Links, href)
+	case "mailto":
+		address, _, _ := strings.Cut(u.Opaque, "?")
+		if address == "" {
+			address = u.Path
+		}
+		if _, err := mail.ParseAddressList(address); err != nil {
+			report.addf(LintError, "broken link %s: %v", href, err)
+		}
+	case "tel", "cid":
+	case "":
+		if u.Path == "" && u.Fragment != "" {
+			return // An anchor within the email
+		}
+		// Recipients open the email outside the site, so relative links
+		// lead nowhere
+		report.addf(LintError, "broken link %s: relative links do not work in email", href)
+	default:
+		report.addf(LintWarning, "link %s uses the unusual scheme %s", href, u.Scheme)
+	}
+}
@@ -0,0 +1,142 @@
+package template
+
+import (
+	"strings"
+)
+
+// preformattedElements keep their whitespace when minifying
+var preformattedElements = map[string]bool{
+	"pre": true, "textarea": true, "script": true,
+}
+
+// layoutElements are not affected by the whitespace around their tags, so it
+// can be dropped
+var layoutElements = map[string]bool{
+	"html": true, "head": true, "body": true, "title": true, "meta": true,
+	"link": true, "style": true, "br": true, "hr": true,
+	"table": true, "thead": true, "tbody": true, "tfoot": true, "tr": true, "td": true, "th": true,
+	"div": true, "p": true, "ul": true, "ol": true, "li": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "footer": true, "section": true, "article": true, "blockquote": true,
+}
+
+// MinifyHTML shrinks a rendered email: comments are removed, except the
+// conditional comments Outlook reads, runs of whitespace are collapsed, the
+// whitespace around block-level tags is dropped and <style> blocks are
+// compacted. Smaller emails avoid Gmail clipping messages over 102 KB.
+func MinifyHTML(htmlContent string) string {
+	tokens := tokenizeHTML(htmlContent)
+	var out strings.Builder
+	preformatted := 0
+	inStyle := false
+
+	for i, token := range tokens {
+		switch token.Type {
+		case commentToken:
+			if strings.HasPrefix(token.Data, "[if") || strings.HasPrefix(token.Data, "<![endif]") {
+				out.WriteString(token.Raw)
+			}
+
+		case textToken:
+			switch {
+			case inStyle:
+				out.WriteString(minifyCSS(token.Raw))
+			case preformatted > 0:
+				out.WriteString(token.Raw)
+			default:
+				text := collapseSpace(token.Raw)
+				if strings.TrimSpace(text) == "" && (isLayoutTag(tokens, i-1) || isLayoutTag(tokens, i+1)) {
+					continue
+				}
+				if isLayoutTag(tokens, i-1) {
+					text = strings.TrimLeft(text, " ")
+				}
+				if isLayoutTag(tokens, i+1) {
+					text = strings.TrimRight(text, " ")
+				}
+				out.WriteString(text)
+			}
+
+		case startTagToken:
+			if preformattedElements[token.Data] {
+				preformatted++
+			}
+			inStyle = token.Data == "style"
+			out.WriteString(token.Raw)
+
+		case endTagToken:
+			if preformattedElements[token.Data] && preformatted > 0 {
+				preformatted--
+			}
+			if token.Data == "style" {
+				inStyle = false
+			}
+			out.WriteString(token.Raw)
+
+		default:
+			out.WriteString(token.Raw)
+		}
+	}
+
+	return strings.TrimSpace(out.String())
+}
+
+// isLayoutTag reports whether tokens[i] is a tag or doctype whose
+// surrounding whitespace does not render
+func isLayoutTag(tokens []htmlToken, i int) bool {
+	if i < 0 || i >= len(tokens) {
+		return true
+	}
+	switch tokens[i].Type {
+	case doctypeToken:
+		return true
+	case startTagToken, endTagToken, selfClosingTagToken:
+		return layoutElements[tokens[i].Data]
+	}
+	return false
+}
+
+// collapseSpace replaces every run of whitespace with a single space
+func collapseSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for i := 0; i < len(s); i++ {
+		if isSpace(s[i]) {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteByte(s[i])
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// minifyCSS removes comments and the whitespace that does not matter in a
+// stylesheet. Spaces inside selectors, where they are combinators, are kept.
+func minifyCSS(css string) string {
+	css = collapseSpace(stripCSSComments(css))
+	var b strings.Builder
+	for i := 0; i < len(css); i++ {
+		c := css[i]
+		if c == ' ' {
+			prev, next := byte(0), byte(0)
+			if b.Len() > 0 {
+				prev = b.String()[b.Len()-1]
+			}
+			if i+1 < len(css) {
+				next = css[i+1]
+			}
+			if prev == 0 || next == 0 || strings.IndexByte("{};:,>", prev) >= 0 || strings.IndexByte("{};,>!", next) >= 0 {
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return strings.ReplaceAll(b.String(), ";}", "}")
+}
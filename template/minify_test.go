@@ -0,0 +1,64 @@
+package template
+
+import "testing"
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "whitespace around block tags",
+			in:   "<div>\n    <p>\n        Hello\n    </p>\n</div>\n",
+			want: "<div><p>Hello</p></div>",
+		},
+		{
+			name: "runs of whitespace in text",
+			in:   "<p>Hello,\n\n    world</p>",
+			want: "<p>Hello, world</p>",
+		},
+		{
+			name: "space between inline tags is kept",
+			in:   "<p><b>Hello</b>  <i>world</i></p>",
+			want: "<p><b>Hello</b> <i>world</i></p>",
+		},
+		{
+			name: "space inside inline tags is kept",
+			in:   "<p>Read <a href=\"x\"> the docs </a> now</p>",
+			want: "<p>Read <a href=\"x\"> the docs </a> now</p>",
+		},
+		{
+			name: "comments are removed",
+			in:   "<p>a<!-- note -->b</p>",
+			want: "<p>ab</p>",
+		},
+		{
+			name: "conditional comments are kept",
+			in:   "<!--[if mso]><table><![endif]-->\n<div>x</div>",
+			want: "<!--[if mso]><table><![endif]--><div>x</div>",
+		},
+		{
+			name: "preformatted text is kept",
+			in:   "<pre>  a\n    b</pre>\n<p>  c  </p>",
+			want: "<pre>  a\n    b</pre><p>c</p>",
+		},
+		{
+			name: "doctype",
+			in:   "<!DOCTYPE html>\n<html>\n<head>\n</head>\n</html>",
+			want: "<!DOCTYPE html><html><head></head></html>",
+		},
+		{
+			name: "style block",
+			in:   "<style>\n  /* base */\n  body {\n    color: #333;\n    margin: 0 auto;\n  }\n  div > p, .a  .b { color: red !important; }\n</style>",
+			want: "<style>body{color:#333;margin:0 auto}div>p,.a .b{color:red!important}</style>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MinifyHTML(tt.in); got != tt.want {
+				t.Errorf("MinifyHTML(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
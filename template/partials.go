@@ -0,0 +1,79 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PartialsDirName is the subdirectory of the template directory that holds
+// layouts and partials shared by every template
+const PartialsDirName = "partials"
+
+// partialSet is the source of the shared layouts and partials. Each file is
+// available as a named template: partials/signature.html can be included
+// with {{template "signature" .}} from HTML templates, and
+// partials/signature.txt from plain-text ones.
+type partialSet struct {
+	html  map[string]string // Template name to source
+	text  map[string]string
+	stamp string // Changes whenever a partial is added, edited or removed
+}
+
+// loadPartials reads the partials in dir. A missing directory means there
+// are no partials.
+func loadPartials(dir string) (*partialSet, error) {
+	set := &partialSet{html: make(map[string]string), text: make(map[string]string)}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return set, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading partials directory: %w", err)
+	}
+
+	var stamps []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".html" && ext != ".txt") {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if ext == ".html" {
+			set.html[name] = string(content)
+		} else {
+			set.text[name] = string(content)
+		}
+		stamps = append(stamps, fmt.Sprintf("%s:%d:%d", entry.Name(), info.ModTime().UnixNano(), info.Size()))
+	}
+
+	sort.Strings(stamps)
+	set.stamp = strings.Join(stamps, ";")
+	return set, nil
+}
+
+// sortedNames returns the keys of a partial map in a stable order, so that
+// parse errors are reported deterministically
+func sortedNames(partials map[string]string) []string {
+	names := make([]string, 0, len(partials))
+	for name := range partials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,634 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go_mailer/logger"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
+)
+
+// templateAliases maps names used in the Google Sheet to template names
+var templateAliases = map[string]string{
+	"normal":  DefaultTemplateName,
+	"default": DefaultTemplateName,
+}
+
+// fileStamp identifies a version of a file; a zero stamp means it is missing
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFile returns the stamp of the file at path
+func statFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fileStamp{}, nil
+	}
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// cachedTemplate is a parsed HTML template with its optional plain-text
+// companion and subject
+type cachedTemplate struct {
+	path         string
+	html         *template.Template
+	text         *texttemplate.Template // nil if the template has no .txt companion
+	subject      *texttemplate.Template // nil if the template defines no subject
+	htmlStamp    fileStamp
+	textStamp    fileStamp
+	subjectStamp fileStamp
+	fields       []Field // Root data fields referenced by the HTML, text and subject
+	strict       bool    // Parsed to fail on fields missing from the data
+}
+
+// withOptionalFields returns the data to execute the template with. In strict
+// mode a missing field fails even where the template tests it or gives it a
+// default, so optional fields the data lacks are set to nil, which reads as
+// empty in {{if .X}} and {{.X | default "y"}}.
+func (c *cachedTemplate) withOptionalFields(data TemplateData) TemplateData {
+	if !c.strict {
+		return data
+	}
+
+	var filled TemplateData
+	for _, f := range c.fields {
+		if _, ok := data[f.Name]; ok || !f.Optional {
+			continue
+		}
+		if filled == nil {
+			filled = Merge(data)
+		}
+		filled[f.Name] = nil
+	}
+	if filled == nil {
+		return data
+	}
+	return filled
+}
+
+// Registry discovers the email templates in a directory, keeps them parsed
+// in memory and, while watching, reloads them when they change on disk.
+// Templates are named after their file without the "_template.html" or
+// ".html" suffix, e.g. "casual" for tamplets/casual_template.html. Every
+// template can use the layouts and partials in the partials subdirectory
+// and the functions in funcs.
+type Registry struct {
+	dir         string
+	partialsDir string
+	names       map[string]string          // Template name to path
+	templates   map[string]*cachedTemplate // Cleaned path to parsed template
+	partials    *partialSet                // Loaded on first use
+	strict      bool                       // Fail on fields missing from the data
+	inlineCSS   bool                       // Copy <style> rules into style attributes
+	minify      bool                       // Minify the rendered HTML
+	stopChan    chan struct{}
+	mu          sync.RWMutex
+}
+
+// NewRegistry creates a registry for the templates in dir. Nothing is read
+// from disk until Load is called or a template is first used.
+func NewRegistry(dir string) *Registry {
+	return &Registry{
+		dir:         dir,
+		partialsDir: filepath.Join(dir, PartialsDirName),
+		names:       make(map[string]string),
+		templates:   make(map[string]*cachedTemplate),
+		inlineCSS:   true,
+	}
+}
+
+// Dir returns the directory the registry discovers templates in
+func (r *Registry) Dir() string {
+	return r.dir
+}
+
+// templateName returns the registry name of a template file, or "" if the
+// file is not an HTML template
+func templateName(file string) string {
+	if !strings.HasSuffix(file, ".html") {
+		return ""
+	}
+	name := strings.TrimSuffix(file, ".html")
+	name = strings.TrimSuffix(name, "_template")
+	return strings.ToLower(name)
+}
+
+// Load discovers and parses every template in the directory. It returns an
+// error if the directory cannot be read or any template fails to parse.
+func (r *Registry) Load() error {
+	names, err := r.discover()
+	if err != nil {
+		return err
+	}
+
+	partials, err := loadPartials(r.partialsDir)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.partials = partials
+	r.templates = make(map[string]*cachedTemplate)
+	r.mu.Unlock()
+
+	var errs []error
+	for _, path := range names {
+		if _, err := r.get(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	r.mu.Lock()
+	r.names = names
+	r.mu.Unlock()
+
+	return errors.Join(errs...)
+}
+
+// discover lists the templates in the directory by name
+func (r *Registry) discover() (map[string]string, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template directory: %w", err)
+	}
+
+	names := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if name := templateName(entry.Name()); name != "" {
+			names[name] = filepath.Join(r.dir, entry.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// Names returns the names of the discovered templates in sorted order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.names))
+	for name := range r.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Path returns the file of the named template. Names are case-insensitive,
+// and an empty name selects the default template.
+func (r *Registry) Path(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = DefaultTemplateName
+	}
+	if alias, ok := templateAliases[name]; ok {
+		name = alias
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	path, ok := r.names[name]
+	if !ok {
+		known := make([]string, 0, len(r.names))
+		for n := range r.names {
+			known = append(known, n)
+		}
+		sort.Strings(known)
+		return "", fmt.Errorf("unknown template '%s' (available: %s)", name, strings.Join(known, ", "))
+	}
+	return path, nil
+}
+
+// get returns the parsed template at path, parsing and caching it on first use
+func (r *Registry) get(path string) (*cachedTemplate, error) {
+	path = filepath.Clean(path)
+
+	r.mu.RLock()
+	cached, ok := r.templates[path]
+	r.mu.RUnlock()
+	if ok {
+		return cached, nil
+	}
+
+	partials, err := r.currentPartials()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	strict := r.strict
+	r.mu.RUnlock()
+
+	cached, err = parseTemplate(path, partials, strict)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.templates[path] = cached
+	r.mu.Unlock()
+
+	return cached, nil
+}
+
+// currentPartials returns the loaded partials, loading them on first use
+func (r *Registry) currentPartials() (*partialSet, error) {
+	r.mu.RLock()
+	partials := r.partials
+	r.mu.RUnlock()
+	if partials != nil {
+		return partials, nil
+	}
+
+	partials, err := loadPartials(r.partialsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.partials = partials
+	r.mu.Unlock()
+
+	return partials, nil
+}
+
+// parseTemplate reads and parses an HTML template, its .txt companion and its
+// subject together with the shared partials. In strict mode executing the
+// templates fails on a field missing from the data instead of rendering
+// "<no value>".
+func parseTemplate(path string, partials *partialSet, strict bool) (*cachedTemplate, error) {
+	missingKey := "missingkey=default"
+	if strict {
+		missingKey = "missingkey=error"
+	}
+
+	cached := &cachedTemplate{path: path, strict: strict}
+
+	var err error
+	if cached.htmlStamp, err = statFile(path); err != nil {
+		return nil, err
+	}
+	rawContent, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The subject comes from front-matter in the HTML file or a sidecar file
+	subjectSource, hasSubject, htmlContent := splitFrontMatter(string(rawContent))
+	subjectPath := SubjectTemplatePath(path)
+	if cached.subjectStamp, err = statFile(subjectPath); err != nil {
+		return nil, err
+	}
+	if !hasSubject && cached.subjectStamp != (fileStamp{}) {
+		subjectContent, err := os.ReadFile(subjectPath)
+		if err != nil {
+			return nil, err
+		}
+		subjectSource, hasSubject = string(subjectContent), true
+	}
+	if hasSubject {
+		if cached.subject, err = texttemplate.New("subject").Funcs(funcs).Option(missingKey).Parse(subjectSource); err != nil {
+			return nil, fmt.Errorf("error parsing subject of template %s: %w", path, err)
+		}
+	}
+
+	cached.html = template.New("email").Funcs(funcs).Option(missingKey)
+	for _, name := range sortedNames(partials.html) {
+		if _, err := cached.html.New(name).Parse(partials.html[name]); err != nil {
+			return nil, fmt.Errorf("error parsing partial %s: %w", name, err)
+		}
+	}
+	// The template is parsed last so that its {{define}} blocks override the
+	// defaults of the layout it uses
+	if _, err = cached.html.Parse(htmlContent); err != nil {
+		return nil, fmt.Errorf("error parsing template %s: %w", path, err)
+	}
+
+	textPath := TextTemplatePath(path)
+	if cached.textStamp, err = statFile(textPath); err != nil {
+		return nil, err
+	}
+	if cached.textStamp != (fileStamp{}) {
+		textContent, err := os.ReadFile(textPath)
+		if err != nil {
+			return nil, err
+		}
+		cached.text = texttemplate.New("email.txt").Funcs(funcs).Option(missingKey)
+		for _, name := range sortedNames(partials.text) {
+			if _, err := cached.text.New(name).Parse(partials.text[name]); err != nil {
+				return nil, fmt.Errorf("error parsing partial %s: %w", name, err)
+			}
+		}
+		if _, err = cached.text.Parse(string(textContent)); err != nil {
+			return nil, fmt.Errorf("error parsing template %s: %w", textPath, err)
+		}
+	}
+
+	// Collected before the first Execute, which rewrites the HTML parse trees
+	// to escape their output
+	cached.fields = collectFields("email", func(name string) *parse.Tree {
+		if t := cached.html.Lookup(name); t != nil {
+			return t.Tree
+		}
+		return nil
+	})
+	if cached.text != nil {
+		cached.fields = mergeFields(cached.fields, collectFields("email.txt", func(name string) *parse.Tree {
+			if t := cached.text.Lookup(name); t != nil {
+				return t.Tree
+			}
+			return nil
+		}))
+	}
+	if cached.subject != nil {
+		cached.fields = mergeFields(cached.fields, collectFields("subject", func(name string) *parse.Tree {
+			if name == "subject" {
+				return cached.subject.Tree
+			}
+			return nil
+		}))
+	}
+
+	return cached, nil
+}
+
+// Fields returns the data fields referenced by the template at path, its
+// plain-text companion, its subject and the partials they include
+func (r *Registry) Fields(path string) ([]Field, error) {
+	cached, err := r.get(path)
+	if err != nil {
+		return nil, err
+	}
+	return cached.fields, nil
+}
+
+// MissingFields returns the required fields of the template at path that
+// data does not provide, so that a job can be rejected when it is scheduled
+// rather than failing when it is sent
+func (r *Registry) MissingFields(path string, data TemplateData) ([]string, error) {
+	fields, err := r.Fields(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, f := range fields {
+		if _, ok := data[f.Name]; !ok && !f.Optional {
+			missing = append(missing, f.Name)
+		}
+	}
+	return missing, nil
+}
+
+// SetInlineCSS turns copying <style> rules into style attributes on or off.
+// It is on by default.
+func (r *Registry) SetInlineCSS(inline bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inlineCSS = inline
+}
+
+// SetMinify turns minifying the rendered HTML on or off. It is off by default.
+func (r *Registry) SetMinify(minify bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.minify = minify
+}
+
+// SetStrict makes rendering fail on fields missing from the data instead of
+// printing "<no value>". Templates already parsed are parsed again.
+func (r *Registry) SetStrict(strict bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.strict != strict {
+		r.strict = strict
+		r.templates = make(map[string]*cachedTemplate)
+	}
+}
+
+// Process renders the HTML template at path, inlines its stylesheet and,
+// if enabled, minifies it. Images referenced by a local path are rewritten
+// to cid: URLs and returned so that the mailer can embed them.
+func (r *Registry) Process(path string, data TemplateData) (string, []InlineImage, error) {
+	cached, err := r.get(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var processedHTML bytes.Buffer
+	if err := cached.html.Execute(&processedHTML, cached.withOptionalFields(data)); err != nil {
+		return "", nil, err
+	}
+
+	r.mu.RLock()
+	inlineCSS, minify := r.inlineCSS, r.minify
+	r.mu.RUnlock()
+
+	rendered := processedHTML.String()
+	if inlineCSS {
+		rendered = InlineCSS(rendered)
+	}
+	if minify {
+		rendered = MinifyHTML(rendered)
+	}
+
+	return embedImages(rendered, filepath.Dir(path))
+}
+
+// ProcessText renders the plain-text companion of the HTML template at path.
+// It returns false if the template has no companion .txt file.
+func (r *Registry) ProcessText(path string, data TemplateData) (string, bool, error) {
+	cached, err := r.get(path)
+	if err != nil {
+		return "", false, err
+	}
+	if cached.text == nil {
+		return "", false, nil
+	}
+
+	var processedText bytes.Buffer
+	if err := cached.text.Execute(&processedText, cached.withOptionalFields(data)); err != nil {
+		return "", false, err
+	}
+
+	return processedText.String(), true, nil
+}
+
+// ProcessSubject renders the subject defined by the HTML template at path.
+// It returns false if the template defines no subject.
+func (r *Registry) ProcessSubject(path string, data TemplateData) (string, bool, error) {
+	cached, err := r.get(path)
+	if err != nil {
+		return "", false, err
+	}
+	if cached.subject == nil {
+		return "", false, nil
+	}
+
+	var processedSubject bytes.Buffer
+	if err := cached.subject.Execute(&processedSubject, cached.withOptionalFields(data)); err != nil {
+		return "", false, err
+	}
+
+	return normalizeSubject(processedSubject.String()), true, nil
+}
+
+// Watch polls the directory every interval and reloads templates that were
+// added, edited or removed. A template that no longer parses keeps its last
+// good version until it is fixed.
+func (r *Registry) Watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	if r.stopChan != nil {
+		r.mu.Unlock()
+		return
+	}
+	r.stopChan = make(chan struct{})
+	stopChan := r.stopChan
+	r.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.reload()
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// StopWatching stops the polling started by Watch
+func (r *Registry) StopWatching() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopChan != nil {
+		close(r.stopChan)
+		r.stopChan = nil
+	}
+}
+
+// reload picks up changes to the directory, the partials and cached
+// templates. A changed partial reloads every template.
+func (r *Registry) reload() {
+	partialsChanged := false
+	partials, err := loadPartials(r.partialsDir)
+	if err != nil {
+		logger.Error("❌ Failed to reload partials: %v", err)
+	} else {
+		r.mu.Lock()
+		if r.partials == nil || r.partials.stamp != partials.stamp {
+			partialsChanged = r.partials != nil
+			r.partials = partials
+		}
+		r.mu.Unlock()
+		if partialsChanged {
+			logger.Info("🔄 Partials changed, reloading every template")
+		}
+	}
+
+	names, err := r.discover()
+	if err != nil {
+		logger.Error("❌ Failed to rescan templates: %v", err)
+	} else {
+		r.mu.Lock()
+		for name, path := range names {
+			if _, known := r.names[name]; !known {
+				logger.Info("🆕 Discovered template '%s' (%s)", name, path)
+			}
+		}
+		for name := range r.names {
+			if _, exists := names[name]; !exists {
+				logger.Info("🗑️ Template '%s' was removed", name)
+			}
+		}
+		r.names = names
+		r.mu.Unlock()
+	}
+
+	r.mu.RLock()
+	cached := make([]*cachedTemplate, 0, len(r.templates))
+	for _, t := range r.templates {
+		cached = append(cached, t)
+	}
+	partials = r.partials
+	strict := r.strict
+	r.mu.RUnlock()
+	if partials == nil {
+		return
+	}
+
+	for _, t := range cached {
+		htmlStamp, htmlErr := statFile(t.path)
+		textStamp, textErr := statFile(TextTemplatePath(t.path))
+		subjectStamp, subjectErr := statFile(SubjectTemplatePath(t.path))
+		if htmlErr != nil || textErr != nil || subjectErr != nil {
+			continue
+		}
+		if !partialsChanged && htmlStamp == t.htmlStamp && textStamp == t.textStamp && subjectStamp == t.subjectStamp {
+			continue
+		}
+
+		if htmlStamp == (fileStamp{}) {
+			// Deleted: drop it so a later use reports the missing file
+			r.mu.Lock()
+			delete(r.templates, t.path)
+			r.mu.Unlock()
+			continue
+		}
+
+		updated, err := parseTemplate(t.path, partials, strict)
+		if err != nil {
+			logger.Error("❌ Keeping previous version of %s, the edited template does not parse: %v", t.path, err)
+			// Remember the broken version so the error is logged only once
+			stale := *t
+			stale.htmlStamp, stale.textStamp, stale.subjectStamp = htmlStamp, textStamp, subjectStamp
+			updated = &stale
+		} else {
+			logger.Info("🔄 Reloaded template %s", t.path)
+		}
+
+		r.mu.Lock()
+		r.templates[t.path] = updated
+		r.mu.Unlock()
+	}
+}
+
+// Default registry instance
+var defaultRegistry = NewRegistry(DefaultTemplateDir)
+
+// SetDefaultRegistry replaces the registry used by Process and ProcessText
+func SetDefaultRegistry(r *Registry) {
+	defaultRegistry = r
+}
+
+// DefaultRegistry returns the registry used by Process and ProcessText
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
@@ -0,0 +1,198 @@
+package template
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTemplate writes an HTML template and returns its path
+func writeTemplate(t *testing.T, dir, name, src string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStrictOptionalFields(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTemplate(t, dir, "offer_template.html", `<!--
+subject: {{.Role}}{{if .Company}} at {{.Company}}{{end}}
+-->
+<p>Hi {{.Name | default "there"}},</p>
+{{if .Referrer}}<p>{{.Referrer}} sent me.</p>{{end}}
+{{with .Links}}{{range .}}<a href="{{.}}">link</a>{{end}}{{end}}
+<p>{{.Role}}</p>`)
+
+	registry := NewRegistry(dir)
+	registry.SetInlineCSS(false)
+	registry.SetStrict(true)
+
+	missing, err := registry.MissingFields(path, TemplateData{"Name": "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(missing); got != "[Role]" {
+		t.Errorf("missing fields = %s, want [Role]", got)
+	}
+
+	// Optional fields may be absent in strict mode
+	rendered, _, err := registry.Process(path, TemplateData{"Role": "Engineer"})
+	if err != nil {
+		t.Fatalf("optional fields failed in strict mode: %v", err)
+	}
+	if !strings.Contains(rendered, "Hi there,") || strings.Contains(rendered, "sent me") {
+		t.Errorf("optional fields rendered wrongly: %s", rendered)
+	}
+	subject, ok, err := registry.ProcessSubject(path, TemplateData{"Role": "Engineer"})
+	if err != nil || !ok || subject != "Engineer" {
+		t.Errorf("subject = %q, %v, %v; want Engineer", subject, ok, err)
+	}
+
+	// A missing required field fails instead of printing <no value>
+	if _, _, err := registry.Process(path, TemplateData{"Name": "Ada"}); err == nil {
+		t.Error("missing required field rendered in strict mode")
+	}
+
+	// The data passed in is not changed
+	data := TemplateData{"Role": "Engineer"}
+	if _, _, err := registry.Process(path, data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 {
+		t.Errorf("Process added fields to the data: %v", data)
+	}
+
+	// Without strict mode the missing field renders empty
+	registry.SetStrict(false)
+	rendered, _, err = registry.Process(path, TemplateData{"Name": "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rendered, "<p></p>") {
+		t.Errorf("missing field rendered as %s", rendered)
+	}
+}
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "logo.png"), []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		src    string
+		errors []string
+		warns  []string
+		links  []string
+	}{
+		{
+			name: "clean",
+			src: `<p>Hi {{.Name}}</p><ul><li>one<li>two</ul>` +
+				`<a href="https://example.com/a">a</a><a href="mailto:ada@example.com?subject=Hi">mail</a>` +
+				`<a href="#top">top</a><a href="tel:+100">call</a><img src="logo.png"><br>`,
+			links: []string{"https://example.com/a"},
+		},
+		{
+			name:   "unknown field",
+			src:    `<p>{{.Name}} {{.Typo}}{{if .Cc}}{{.Cc}}{{end}}</p>`,
+			errors: []string{"unknown field .Typo: not in the data"},
+		},
+		{
+			name: "unbalanced markup",
+			src:  `<div><span>x</div></b><table>`,
+			errors: []string{
+				"unbalanced HTML: <span> is not closed before </div>",
+				"unbalanced HTML: </b> has no opening tag",
+				"unbalanced HTML: <table> is never closed",
+			},
+		},
+		{
+			name: "links",
+			src: `<a href="/jobs">a</a><a href="mailto:not an address">b</a><a href="https:///x">c</a>` +
+				`<a href="">d</a><a href="ftp://example.com/f">e</a>`,
+			errors: []string{
+				"broken link /jobs: relative links do not work in email",
+				"broken link mailto:not an address",
+				"broken link https:///x: no host",
+			},
+			warns: []string{
+				"link with an empty target",
+				"link ftp://example.com/f uses the unusual scheme ftp",
+			},
+		},
+		{
+			name: "images",
+			src:  `<img alt="x"><img src="missing.png"><img src="../logo.png"><img src="https://example.com/i.png">`,
+			errors: []string{
+				"image without a src",
+				"broken image missing.png",
+				"inline image ../logo.png is outside the template directory",
+			},
+			links: []string{"https://example.com/i.png"},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTemplate(t, dir, fmt.Sprintf("lint%d.html", i), "<!-- subject: Hi -->\n"+tt.src)
+			report := NewRegistry(dir).Lint(path, TemplateData{"Name": "Ada"})
+
+			var errors, warns []string
+			for _, issue := range report.Issues {
+				if issue.Severity == LintError {
+					errors = append(errors, issue.Message)
+				} else {
+					warns = append(warns, issue.Message)
+				}
+			}
+			checkMessages(t, "errors", errors, tt.errors)
+			checkMessages(t, "warnings", warns, tt.warns)
+			if report.HasErrors() != (len(tt.errors) > 0) {
+				t.Errorf("HasErrors = %v with %v", report.HasErrors(), errors)
+			}
+			if fmt.Sprint(report.Links) != fmt.Sprint(tt.links) {
+				t.Errorf("links = %v, want %v", report.Links, tt.links)
+			}
+		})
+	}
+}
+
+func TestLintWithoutSubject(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTemplate(t, dir, "plain.html", `<p>Hi</p>`)
+	report := NewRegistry(dir).Lint(path, nil)
+	checkMessages(t, "warnings", messages(report.Issues), []string{"no subject defined"})
+	if report.HasErrors() {
+		t.Errorf("errors reported: %v", report.Issues)
+	}
+}
+
+// messages returns the messages of issues
+func messages(issues []LintIssue) []string {
+	var list []string
+	for _, issue := range issues {
+		list = append(list, issue.Message)
+	}
+	return list
+}
+
+// checkMessages reports unless every message starts with the matching prefix
+// in want
+func checkMessages(t *testing.T, kind string, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s = %q, want %q", kind, got, want)
+		return
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("%s = %q, want %q", kind, got, want)
+			return
+		}
+	}
+}
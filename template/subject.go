@@ -0,0 +1,49 @@
+package template
+
+import (
+	"strings"
+)
+
+// SubjectTemplatePath returns the path of the subject sidecar of an HTML
+// template, e.g. tamplets/email_template.subject for tamplets/email_template.html
+func SubjectTemplatePath(htmlTemplatePath string) string {
+	return strings.TrimSuffix(htmlTemplatePath, ".html") + ".subject"
+}
+
+// splitFrontMatter separates the front-matter comment at the top of an HTML
+// template from its body. Front-matter is an HTML comment of "key: value"
+// lines:
+//
+//	<!--
+//	subject: Regarding {{.ApplyingForRoll}} Position at {{.CompanyName}}
+//	-->
+//
+// A leading comment without a subject line is left in the body.
+func splitFrontMatter(src string) (subject string, ok bool, body string) {
+	trimmed := strings.TrimLeft(src, " \t\r\n\ufeff")
+	if !strings.HasPrefix(trimmed, "<!--") {
+		return "", false, src
+	}
+	end := strings.Index(trimmed, "-->")
+	if end < 0 {
+		return "", false, src
+	}
+
+	for _, line := range strings.Split(trimmed[len("<!--"):end], "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if found && strings.EqualFold(strings.TrimSpace(key), "subject") {
+			subject, ok = strings.TrimSpace(value), true
+		}
+	}
+	if !ok {
+		return "", false, src
+	}
+
+	return subject, true, strings.TrimLeft(trimmed[end+len("-->"):], "\r\n")
+}
+
+// normalizeSubject collapses a rendered subject onto one line, which also
+// keeps data from injecting headers
+func normalizeSubject(subject string) string {
+	return strings.Join(strings.Fields(subject), " ")
+}
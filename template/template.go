@@ -1,39 +1,47 @@
 package template
 
-import (
-	"bytes"
-	"html/template"
-	"os"
-)
+// TemplateData holds the data to be injected into the email template, keyed
+// by placeholder name: {{.RecipientName}} reads TemplateData["RecipientName"].
+// Keys that are not valid identifiers, such as sheet columns with spaces, can
+// be read with {{index . "Referrer Name"}}.
+type TemplateData map[string]interface{}
 
-// TemplateData holds the data to be injected into the email template
-type TemplateData struct {
-	RecipientName   string
-	CompanyName     string
-	ApplyingForRoll string
-}
-
-// Process reads an HTML template file and replaces placeholder values with actual data
-func Process(templatePath string, data TemplateData) (string, error) {
-	// First read the template file
-	htmlContent, err := os.ReadFile(templatePath)
-	if err != nil {
-		return "", err
+// Merge layers data sets into a new TemplateData. Later layers win, so the
+// usual order is global variables, then per-recipient extras, then the fixed
+// fields.
+func Merge(layers ...TemplateData) TemplateData {
+	merged := make(TemplateData)
+	for _, layer := range layers {
+		for key, value := range layer {
+			merged[key] = value
+		}
 	}
+	return merged
+}
 
-	htmlString := string(htmlContent)
+// Process renders the HTML template at templatePath with the default
+// registry, which parses each template once and caches it. Images referenced
+// by a local path are rewritten to cid: URLs and returned so that the mailer
+// can embed them.
+func Process(templatePath string, data TemplateData) (string, []InlineImage, error) {
+	return defaultRegistry.Process(templatePath, data)
+}
 
-	// Create a template with placeholder replacements
-	tmpl, err := template.New("email").Parse(htmlString)
-	if err != nil {
-		return "", err
-	}
+// ProcessText renders the plain-text companion of an HTML template with the
+// default registry. It returns false if the template has no companion .txt file.
+func ProcessText(htmlTemplatePath string, data TemplateData) (string, bool, error) {
+	return defaultRegistry.ProcessText(htmlTemplatePath, data)
+}
 
-	// Execute the template with the provided data
-	var processedHTML bytes.Buffer
-	if err := tmpl.Execute(&processedHTML, data); err != nil {
-		return "", err
-	}
+// ProcessSubject renders the subject defined by an HTML template with the
+// default registry. It returns false if the template defines no subject.
+func ProcessSubject(htmlTemplatePath string, data TemplateData) (string, bool, error) {
+	return defaultRegistry.ProcessSubject(htmlTemplatePath, data)
+}
 
-	return processedHTML.String(), nil
+// MissingFields returns the required fields of an HTML template, its
+// plain-text companion and its subject that data does not provide, using the
+// default registry
+func MissingFields(htmlTemplatePath string, data TemplateData) ([]string, error) {
+	return defaultRegistry.MissingFields(htmlTemplatePath, data)
 }
@@ -0,0 +1,209 @@
+package template
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+// TextTemplatePath returns the path of the plain-text companion of an HTML
+// template, e.g. tamplets/email_template.txt for tamplets/email_template.html
+func TextTemplatePath(htmlTemplatePath string) string {
+	return strings.TrimSuffix(htmlTemplatePath, ".html") + ".txt"
+}
+
+// blockElements start on a new line in the plain-text rendering
+var blockElements = map[string]bool{
+	"address": true, "article": true, "blockquote": true, "div": true,
+	"footer": true, "header": true, "hr": true, "p": true, "section": true,
+	"table": true, "tr": true, "ul": true, "ol": true, "pre": true,
+}
+
+// hiddenElements have no visible text
+var hiddenElements = map[string]bool{
+	"head": true, "script": true, "style": true, "title": true,
+}
+
+// textWriter accumulates plain text while collapsing whitespace the way a
+// browser would and limiting blank lines to one
+type textWriter struct {
+	buf          strings.Builder
+	pendingSpace bool
+	newlines     int
+}
+
+// text writes a run of inline text
+func (w *textWriter) text(s string) {
+	if s == "" {
+		return
+	}
+	if first, _ := utf8.DecodeRuneInString(s); isTextSpace(first) {
+		w.pendingSpace = true
+	}
+
+	for i, field := range strings.FieldsFunc(s, isTextSpace) {
+		if (i > 0 || w.pendingSpace) && w.newlines == 0 && w.buf.Len() > 0 {
+			w.buf.WriteByte(' ')
+		}
+		w.buf.WriteString(field)
+		w.pendingSpace = false
+		w.newlines = 0
+	}
+
+	if last, _ := utf8.DecodeLastRuneInString(s); isTextSpace(last) {
+		w.pendingSpace = true
+	}
+}
+
+// raw writes text without collapsing whitespace
+func (w *textWriter) raw(s string) {
+	w.buf.WriteString(s)
+	w.pendingSpace = false
+	w.newlines = 0
+}
+
+// lineBreak ends the current line; blank lines are capped at one
+func (w *textWriter) lineBreak(count int) {
+	if w.buf.Len() == 0 {
+		return
+	}
+	for w.newlines < count && w.newlines < 2 {
+		w.buf.WriteByte('\n')
+		w.newlines++
+	}
+	w.pendingSpace = false
+}
+
+// isTextSpace reports whether r is whitespace
+func isTextSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '\u00a0'
+}
+
+// HTMLToText derives a readable plain-text version of a rendered HTML email.
+// Headings are underlined, list items are bulleted or numbered, and link
+// targets are listed as numbered footnotes at the end.
+func HTMLToText(htmlContent string) string {
+	var w textWriter
+	var links []string
+	var lists []int // Item counter per open list, -1 for unordered lists
+	var hrefs []string
+	var headingStart int
+	hidden := 0
+
+	for _, token := range tokenizeHTML(htmlContent) {
+		switch token.Type {
+		case textToken:
+			if hidden == 0 {
+				w.text(html.UnescapeString(token.Data))
+			}
+
+		case startTagToken, selfClosingTagToken:
+			if hiddenElements[token.Data] {
+				if token.Type == startTagToken {
+					hidden++
+				}
+				continue
+			}
+			if hidden > 0 {
+				continue
+			}
+
+			switch token.Data {
+			case "br":
+				w.lineBreak(1)
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				w.lineBreak(2)
+				headingStart = w.buf.Len()
+			case "ul":
+				w.lineBreak(1)
+				lists = append(lists, -1)
+			case "ol":
+				w.lineBreak(1)
+				lists = append(lists, 0)
+			case "li":
+				w.lineBreak(1)
+				if len(lists) > 0 && lists[len(lists)-1] >= 0 {
+					lists[len(lists)-1]++
+					w.raw(fmt.Sprintf("%s%d. ", strings.Repeat("  ", len(lists)-1), lists[len(lists)-1]))
+				} else {
+					w.raw(strings.Repeat("  ", len(lists)) + "- ")
+				}
+			case "td", "th":
+				w.text(" ")
+			case "img":
+				if alt, ok := token.attr("alt"); ok && alt != "" {
+					w.text(" " + alt + " ")
+				}
+			case "a":
+				href, _ := token.attr("href")
+				hrefs = append(hrefs, href)
+			case "hr":
+				w.lineBreak(1)
+				w.raw("----------------------------------------")
+				w.lineBreak(1)
+			default:
+				if blockElements[token.Data] {
+					w.lineBreak(2)
+				}
+			}
+
+		case endTagToken:
+			if hiddenElements[token.Data] {
+				if hidden > 0 {
+					hidden--
+				}
+				continue
+			}
+			if hidden > 0 {
+				continue
+			}
+
+			switch token.Data {
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				heading := strings.TrimSpace(w.buf.String()[headingStart:])
+				underline := "-"
+				if token.Data == "h1" {
+					underline = "="
+				}
+				if heading != "" {
+					w.lineBreak(1)
+					w.raw(strings.Repeat(underline, len([]rune(heading))))
+				}
+				w.lineBreak(2)
+			case "ul", "ol":
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+				w.lineBreak(2)
+			case "a":
+				if len(hrefs) == 0 {
+					continue
+				}
+				href := hrefs[len(hrefs)-1]
+				hrefs = hrefs[:len(hrefs)-1]
+				if href == "" || strings.HasPrefix(href, "#") {
+					continue
+				}
+				links = append(links, html.UnescapeString(href))
+				w.raw(fmt.Sprintf(" [%d]", len(links)))
+			default:
+				if blockElements[token.Data] {
+					w.lineBreak(2)
+				}
+			}
+		}
+	}
+
+	text := strings.TrimSpace(w.buf.String())
+	if len(links) > 0 {
+		var footnotes strings.Builder
+		footnotes.WriteString("\n\n")
+		for i, link := range links {
+			footnotes.WriteString(fmt.Sprintf("[%d] %s\n", i+1, link))
+		}
+		text += strings.TrimRight(footnotes.String(), "\n")
+	}
+
+	return text + "\n"
+}